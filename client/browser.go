@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/discovery"
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/juanefec/go-pixel-ao/registry"
+)
+
+const (
+	BrowserRefresh = 3 * time.Second
+	// BrowserWait is how long answers to a probe are collected
+	BrowserWait = time.Second
+)
+
+// BrowserEntry is a server listed in the login, Info is nil for bookmarks
+// that didn't answer. Servers listed by the master that didn't answer have
+// no ping.
+type BrowserEntry struct {
+	Addr     string
+	Info     *discovery.Server
+	Bookmark bool
+}
+
+// Browser lists the servers found on the LAN, the ones the master server
+// lists and the bookmarked ones, probing them in the background until Stop
+type Browser struct {
+	bookmarks []string
+	master    string // url, empty to only look in the LAN
+	found     []discovery.Server
+	listed    []registry.Entry
+	mutex     *sync.Mutex
+	stop      chan struct{}
+	stopOnce  *sync.Once
+}
+
+func NewBrowser(bookmarks []string, master string) *Browser {
+	b := &Browser{
+		bookmarks: bookmarks,
+		master:    master,
+		mutex:     &sync.Mutex{},
+		stop:      make(chan struct{}),
+		stopOnce:  &sync.Once{},
+	}
+	go b.run()
+	return b
+}
+
+func (b *Browser) run() {
+	tick := time.NewTicker(BrowserRefresh)
+	defer tick.Stop()
+	failing := false
+	for {
+		var listed []registry.Entry
+		if b.master != "" {
+			var err error
+			listed, err = registry.List(b.master)
+			if err != nil && !failing {
+				slog.Warn("Listing the master server", "master", b.master, "err", err)
+			}
+			failing = err != nil
+		}
+		// the listed servers are probed too for their ping
+		addrs := append([]string{}, b.bookmarks...)
+		for _, e := range listed {
+			addrs = append(addrs, e.Addr)
+		}
+		found, err := discovery.Query([]int{discovery.DefaultPort}, addrs, BrowserWait)
+		if err == nil {
+			b.mutex.Lock()
+			b.found, b.listed = found, listed
+			b.mutex.Unlock()
+		}
+		select {
+		case <-tick.C:
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Servers lists what answered by ping, then what the master lists and
+// didn't answer, then the bookmarks that didn't
+func (b *Browser) Servers() []BrowserEntry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	entries := []BrowserEntry{}
+	answered := map[string]bool{}
+	for i := range b.found {
+		s := &b.found[i]
+		answered[s.Addr] = true
+		entries = append(entries, BrowserEntry{Addr: s.Addr, Info: s, Bookmark: b.bookmarked(s.Addr)})
+	}
+	for _, e := range b.listed {
+		if !answered[e.Addr] {
+			answered[e.Addr] = true
+			info := &discovery.Server{ServerInfoMsg: e.ServerInfoMsg, Addr: e.Addr}
+			entries = append(entries, BrowserEntry{Addr: e.Addr, Info: info, Bookmark: b.bookmarked(e.Addr)})
+		}
+	}
+	for _, addr := range b.bookmarks {
+		if !answered[addr] {
+			entries = append(entries, BrowserEntry{Addr: addr, Bookmark: true})
+		}
+	}
+	return entries
+}
+
+func (b *Browser) bookmarked(addr string) bool {
+	for _, a := range b.bookmarks {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Browser) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+// String is the row of e in the browser
+func (e BrowserEntry) String() string {
+	mark := " "
+	if e.Bookmark {
+		mark = "*"
+	}
+	if e.Info == nil {
+		return tr("browser.silent", mark, PadRight(e.Addr, " ", 40))
+	}
+	i := e.Info
+	if i.Version != models.ProtocolVersion {
+		return tr("browser.version", mark, PadRight(i.Name, " ", 18), PadRight(e.Addr, " ", 21), i.Version)
+	}
+	players := tr("browser.players", i.Players)
+	ping := "?"
+	if i.Ping > 0 {
+		ping = fmt.Sprintf("%dms", i.Ping.Milliseconds())
+	}
+	return tr("browser.server", mark, PadRight(i.Name, " ", 18), PadRight(e.Addr, " ", 21), PadRight(tr("mode."+i.Mode), " ", 16), PadRight(players, " ", 12), ping)
+}
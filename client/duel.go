@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/faiface/pixel"
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+// Duel is the duel the player is in, its State is DuelNone otherwise
+var Duel = models.DuelMsg{}
+
+// UpdateDuel applies a duel state sent by the server: on the countdown the
+// player is moved to its spot, healed and held still until the fight starts
+func UpdateDuel(p *Player, d models.DuelMsg) {
+	switch d.State {
+	case models.DuelCountdown:
+		p.pos = pixel.V(d.X, d.Y)
+		p.dead = false
+		p.hp = p.maxhp
+		p.mp = p.maxmp
+		p.rooted = true
+		p.lastRootedStart = time.Now().Add(time.Duration(d.Remaining * float64(time.Second)))
+		p.duel, p.duelFighting = d.ID, false
+	case models.DuelFighting:
+		p.duelFighting = true
+	case models.DuelEnded:
+		p.dead = false
+		p.hp = p.maxhp
+		p.mp = p.maxmp
+		p.duel, p.duelFighting = ksuid.Nil, false
+		d = models.DuelMsg{}
+	}
+	d.Remaining = math.Max(d.Remaining, 0)
+	Duel = d
+	duelUpdated = time.Now()
+}
+
+// duelUpdated is when Duel.Remaining was received
+var duelUpdated time.Time
+
+// duelStatus is the countdown or the fight timer of the current duel
+func duelStatus() string {
+	left := int(math.Ceil(Duel.Remaining - time.Since(duelUpdated).Seconds()))
+	if left < 0 {
+		left = 0
+	}
+	switch Duel.State {
+	case models.DuelCountdown:
+		return tr("duel.countdown", Duel.OpponentName, left)
+	case models.DuelFighting:
+		return tr("duel.fighting", Duel.OpponentName, left/60, left%60)
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/juanefec/go-pixel-ao/client/socket"
+	"github.com/juanefec/go-pixel-ao/models"
+	"golang.org/x/image/colornames"
+)
+
+const (
+	FlagBaseRadius = 40.0
+)
+
+// Flags is the capture-the-flag state last sent by the server
+var Flags = []models.FlagMsg{}
+
+// DrawFlags draws both bases and flags, carried flags float over the carrier
+func DrawFlags(win *pixelgl.Window, s *socket.Socket, pd *PlayersData, p *Player) {
+	if Match.Mode != "ctf" {
+		return
+	}
+	imd := imdraw.New(nil)
+	for _, f := range Flags {
+		base := pixel.V(f.BaseX, f.BaseY)
+		imd.Color = TeamColor(f.Team)
+		imd.Push(base)
+		imd.Circle(FlagBaseRadius, 2)
+
+		pos := pixel.V(f.X, f.Y)
+		if f.State == models.FlagCarried {
+			if carrier := pd.Get(f.Carrier, s.ClientID, p); carrier != nil {
+				pos = carrier.pos.Add(pixel.V(8, 20))
+			}
+		}
+		imd.Color = colornames.Saddlebrown
+		imd.Push(pos, pos.Add(pixel.V(0, 40)))
+		imd.Line(2)
+		imd.Color = TeamColor(f.Team)
+		imd.Push(pos.Add(pixel.V(0, 40)), pos.Add(pixel.V(22, 33)), pos.Add(pixel.V(0, 26)))
+		imd.Polygon(0)
+	}
+	imd.Draw(win)
+}
+
+// FlagScores returns the captures of the red and blue teams
+func FlagScores() (red, blue int) {
+	for _, f := range Flags {
+		switch f.Team {
+		case models.RedTeam:
+			red = f.Score
+		case models.BlueTeam:
+			blue = f.Score
+		}
+	}
+	return
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
+	"github.com/juanefec/go-pixel-ao/models"
 )
 
 type TreeType int
@@ -49,7 +50,7 @@ type Forest struct {
 
 func generateRandomPoss(c, bot, top, left, rigth int64) []pixel.Vec {
 	poss := make([]pixel.Vec, c)
-	rand.Seed(c + top + bot + left + rigth)
+	rand.Seed(models.ForestSeed + c + top + bot + left + rigth)
 	for i := range poss {
 		poss[i] = pixel.V(random(left, rigth), random(bot, top))
 	}
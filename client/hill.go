@@ -0,0 +1,83 @@
+package main
+
+import (
+	"image/color"
+	"strings"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/juanefec/go-pixel-ao/models"
+	"golang.org/x/image/colornames"
+)
+
+// Hill is the king of the hill control point last sent by the server
+var Hill = models.HillMsg{}
+
+func hillRunning() bool {
+	return (Match.Mode == "koth" || Match.Mode == "tkoth") && Match.State == models.MatchRunning
+}
+
+// holderColor is the team colour in team play and gold for a single player
+func holderColor(h models.HillHolder) color.Color {
+	switch {
+	case h.Team != models.NoTeam:
+		return TeamColor(h.Team)
+	case h.Name != "":
+		return colornames.Gold
+	}
+	return colornames.White
+}
+
+func holderName(h models.HillHolder) string {
+	switch h.Team {
+	case models.RedTeam:
+		return tr("team.red")
+	case models.BlueTeam:
+		return tr("team.blue")
+	}
+	return strings.TrimSpace(h.Name)
+}
+
+// DrawHill draws the control point ring in the colour of whoever holds it
+func DrawHill(win *pixelgl.Window) {
+	if !hillRunning() || Hill.Radius <= 0 {
+		return
+	}
+	imd := imdraw.New(nil)
+	imd.Color = holderColor(Hill.Controller)
+	if Hill.Contested {
+		imd.Color = colornames.Orange
+	}
+	imd.Push(pixel.V(Hill.X, Hill.Y))
+	imd.Circle(Hill.Radius, 6)
+	imd.Draw(win)
+}
+
+// DrawHillProgress draws the capture bar centered on pos
+func DrawHillProgress(win *pixelgl.Window, pos pixel.Vec) {
+	if Hill.Capturer == (models.HillHolder{}) {
+		return
+	}
+	size := pixel.V(200, 10)
+	imd := imdraw.New(nil)
+	imd.Color = colornames.Black
+	imd.Push(pos.Sub(size.Scaled(0.5)), pos.Add(size.Scaled(0.5)))
+	imd.Rectangle(0)
+	imd.Color = holderColor(Hill.Capturer)
+	from := pos.Sub(size.Scaled(0.5))
+	imd.Push(from, from.Add(pixel.V(size.X*Hill.Progress, size.Y)))
+	imd.Rectangle(0)
+	imd.Draw(win)
+}
+
+// hillStatus is the leader of the hill and its points
+func hillStatus() string {
+	if Hill.Contested {
+		return tr("hill.contested")
+	}
+	if len(Hill.Scores) == 0 {
+		return tr("hill.nobody")
+	}
+	return tr("hill.leader", holderName(Hill.Scores[0].HillHolder), Hill.Scores[0].Points)
+}
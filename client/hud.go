@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"image/color"
+	"math"
 	"strings"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
 	"github.com/faiface/pixel/text"
+	"github.com/juanefec/go-pixel-ao/models"
 	"golang.org/x/image/colornames"
 	"golang.org/x/image/font/basicfont"
 )
@@ -114,6 +116,12 @@ const (
 	Ranking8
 	Ranking9
 	Ranking10
+	MatchTimer
+	MatchResults
+	MatchScore
+	SpectatingNotice
+	DuelStatus
+	SpectatorInfo
 )
 
 type IconType int
@@ -261,19 +269,19 @@ func NewPlayerInfo(player *Player, pd *PlayersData, spells SpellKinds) *PlayerIn
 		break
 	}
 
-	hudProps := make([]*TextProp, 23)
+	hudProps := make([]*TextProp, 29)
 	basicAtlas := text.NewAtlas(basicfont.Face7x13, text.ASCII)
 	hudProps[HealthNumber] = NewTextProp(basicAtlas, "%v/%v", player.hp, player.maxhp)
 	hudProps[ManaNumber] = NewTextProp(basicAtlas, "%v/%v", player.mp, player.maxmp)
-	hudProps[OnlineCount] = NewTextProp(basicAtlas, "Typing...")
-	hudProps[PosXY] = NewTextProp(basicAtlas, "Online: %v", pd.Online+1)
+	hudProps[OnlineCount] = NewTextProp(basicAtlas, tr("hud.typing"))
+	hudProps[PosXY] = NewTextProp(basicAtlas, tr("hud.online", pd.Online+1))
 	hudProps[TypingMark] = NewTextProp(basicAtlas, "X: %v\nY: %v", player.pos.X, player.pos.Y)
 	hudProps[FPSCount] = NewTextProp(basicAtlas, "FPS: %v", 0)
 	hudProps[ZoomINButton] = NewTextProp(basicAtlas, "in")
 	hudProps[ZoomOUTButton] = NewTextProp(basicAtlas, "out")
-	hudProps[ZoomTitle] = NewTextProp(basicAtlas, "Z to toggle")
-	hudProps[KDCount] = NewTextProp(basicAtlas, "K/D: %v/%v", player.kills, player)
-	hudProps[RankingTitle] = NewTextProp(basicAtlas, "Top 10           K     D")
+	hudProps[ZoomTitle] = NewTextProp(basicAtlas, tr("hud.zoom_toggle"))
+	hudProps[KDCount] = NewTextProp(basicAtlas, tr("hud.kd", player.kills, player.deaths))
+	hudProps[RankingTitle] = NewTextProp(basicAtlas, tr("hud.ranking_title"))
 	hudProps[Ranking1] = NewTextProp(basicAtlas, "1: %v 	| %v | %v", "-", 0, 0)
 	hudProps[Ranking2] = NewTextProp(basicAtlas, "2: %v 	| %v | %v", "-", 0, 0)
 	hudProps[Ranking3] = NewTextProp(basicAtlas, "3: %v 	| %v | %v", "-", 0, 0)
@@ -286,6 +294,12 @@ func NewPlayerInfo(player *Player, pd *PlayersData, spells SpellKinds) *PlayerIn
 	hudProps[Ranking10] = NewTextProp(basicAtlas, "10: %v 	| %v | %v", "-", 0, 0)
 	hudProps[PrimarySpellCharges] = NewTextProp(basicAtlas, fmt.Sprint(pi.PrimarySpell.MaxCharges))
 	hudProps[SecondarySpellCharges] = NewTextProp(basicAtlas, fmt.Sprint(pi.SecondarySpell.MaxCharges))
+	hudProps[MatchTimer] = NewTextProp(basicAtlas, tr("match.waiting"))
+	hudProps[MatchResults] = NewTextProp(basicAtlas, "")
+	hudProps[MatchScore] = NewTextProp(basicAtlas, "")
+	hudProps[SpectatingNotice] = NewTextProp(basicAtlas, tr("zone.spectating"))
+	hudProps[DuelStatus] = NewTextProp(basicAtlas, "")
+	hudProps[SpectatorInfo] = NewTextProp(basicAtlas, "")
 
 	pi.player = player
 	pi.playersData = pd
@@ -481,7 +495,7 @@ func (pi *PlayerInfo) Draw(win *pixelgl.Window, cam pixel.Matrix, cursor *Cursor
 	pi.hudText[HealthNumber].Draw(win, pixel.IM.Moved(topRigthInfoPos.Add(pixel.V(46, 6))), "%v/%v", int(pi.player.hp), int(pi.player.maxhp))
 	pi.hudText[ManaNumber].Draw(win, pixel.IM.Moved(topRigthInfoPos.Add(pixel.V(40, -25))), "%v/%v", int(pi.player.mp), int(pi.player.maxmp))
 	topLeftInfoPos := cam.Unproject(pixel.V(30, winSize.Y-50))
-	pi.hudText[OnlineCount].Draw(win, pixel.IM.Moved(topLeftInfoPos).Scaled(topLeftInfoPos, 2), "Online: %v", pi.playersData.Online+1)
+	pi.hudText[OnlineCount].Draw(win, pixel.IM.Moved(topLeftInfoPos).Scaled(topLeftInfoPos, 2), tr("hud.online", pi.playersData.Online+1))
 	pi.hudText[FPSCount].Draw(win, pixel.IM.Moved(topLeftInfoPos.Add(pixel.V(0, -20))), "FPS: %v", pi.nfps)
 	pi.hudText[PosXY].Draw(win, pixel.IM.Moved(topLeftInfoPos.Add(pixel.V(0, -40))), "X: %v\nY: %v", int(pi.player.pos.X/10), int(pi.player.pos.Y/10))
 
@@ -491,14 +505,14 @@ func (pi *PlayerInfo) Draw(win *pixelgl.Window, cam pixel.Matrix, cursor *Cursor
 		pi.hudText[ZoomOUTButton].Draw(win, pixel.IM.Moved(zoomTogglePos.Add(pixel.V(3, 5))), "x1")
 	}
 	if drawTitle {
-		pi.hudText[ZoomTitle].Draw(win, pixel.IM.Moved(titlePos.Add(pixel.V(-78, -16))), "Z to toggle")
+		pi.hudText[ZoomTitle].Draw(win, pixel.IM.Moved(titlePos.Add(pixel.V(-78, -16))), tr("hud.zoom_toggle"))
 	}
 
-	pi.hudText[KDCount].Draw(win, pixel.IM.Moved(topRigthInfoPos.Add(pixel.V(-80, 10))), "K/D: %v/%v", pi.player.kills, pi.player.deaths)
+	pi.hudText[KDCount].Draw(win, pixel.IM.Moved(topRigthInfoPos.Add(pixel.V(-80, 10))), tr("hud.kd", pi.player.kills, pi.player.deaths))
 
 	pi.hudText[TypingMark].Text.Clear()
 	if pi.player.chat.chatting {
-		pi.hudText[TypingMark].Draw(win, pixel.IM.Moved(topRigthInfoPos.Add(pixel.V(-80, -10))), "Typing...")
+		pi.hudText[TypingMark].Draw(win, pixel.IM.Moved(topRigthInfoPos.Add(pixel.V(-80, -10))), tr("hud.typing"))
 	}
 
 	// Draw tab ranking status
@@ -521,12 +535,63 @@ func (pi *PlayerInfo) Draw(win *pixelgl.Window, cam pixel.Matrix, cursor *Cursor
 		topLeftRankingPos := centerBasedPos.Add(pixel.V(-133, 140))
 		pi.hudText[RankingTitle].Draw(win, pixel.IM.Moved(topLeftRankingPos.Add(pixel.V(80, -5))), pi.hudText[RankingTitle].SText)
 		for i := Ranking1; i <= myTop; i++ {
-			pi.hudText[i].Draw(win, pixel.IM.Moved(topLeftRankingPos.Add(pixel.V(0, -c*25))), "%v   | %v| %v|", PadRight(fmt.Sprintf("%v: %v", i-Ranking1+1, strings.TrimSpace(Ranking[i-Ranking1].Name)), " ", 23), PadRight(fmt.Sprint(Ranking[i-Ranking1].K), " ", 4), PadRight(fmt.Sprint(Ranking[i-Ranking1].D), " ", 4))
+			pi.hudText[i].Draw(win, pixel.IM.Moved(topLeftRankingPos.Add(pixel.V(0, -c*25))), "%v   | %v| %v|", PadRight(fmt.Sprintf("%v: %v", i-Ranking1+1, rankingName(Ranking[i-Ranking1])), " ", 23), PadRight(fmt.Sprint(Ranking[i-Ranking1].K), " ", 4), PadRight(fmt.Sprint(Ranking[i-Ranking1].D), " ", 4))
 			c++
 		}
 
 	}
 
+	// Match timer
+	topCenterPos := cam.Unproject(pixel.V(winSize.X/2, winSize.Y-30))
+	timerWidth := pi.hudText[MatchTimer].Text.Bounds().W()
+	pi.hudText[MatchTimer].Draw(win, pixel.IM.Moved(topCenterPos.Sub(pixel.V(timerWidth/2, 0))).Scaled(topCenterPos, 2), "%v", matchStatus())
+	scoreLine := ""
+	switch Match.Mode {
+	case "ctf":
+		red, blue := FlagScores()
+		scoreLine = tr("match.team_scores", red, blue)
+	case "br":
+		if Match.State == models.MatchRunning {
+			scoreLine = zoneStatus()
+		}
+	case "koth", "tkoth":
+		if Match.State == models.MatchRunning {
+			scoreLine = hillStatus()
+			DrawHillProgress(win, topCenterPos.Add(pixel.V(0, -50)))
+		}
+	}
+	scorePos := topCenterPos.Add(pixel.V(0, -30))
+	scoreWidth := pi.hudText[MatchScore].Text.Bounds().W()
+	pi.hudText[MatchScore].Draw(win, pixel.IM.Moved(scorePos.Sub(pixel.V(scoreWidth/2, 0))), "%v", scoreLine)
+	if pi.player.dead && Match.NoRespawn {
+		noticePos := cam.Unproject(pixel.V(winSize.X/2, winSize.Y/4))
+		noticeWidth := pi.hudText[SpectatingNotice].Text.Bounds().W()
+		pi.hudText[SpectatingNotice].Draw(win, pixel.IM.Moved(noticePos.Sub(pixel.V(noticeWidth/2, 0))), "%v", tr("zone.spectating"))
+	}
+	if Spectating(pi.player) {
+		infoPos := cam.Unproject(pixel.V(winSize.X/2, 120))
+		infoWidth := pi.hudText[SpectatorInfo].Text.Bounds().W()
+		pi.hudText[SpectatorInfo].Draw(win, pixel.IM.Moved(infoPos.Sub(pixel.V(infoWidth/2, 0))), "%v", pi.spectatorInfo())
+	}
+	if status := duelStatus(); status != "" {
+		duelPos := cam.Unproject(pixel.V(winSize.X/2, winSize.Y/3))
+		duelWidth := pi.hudText[DuelStatus].Text.Bounds().W()
+		pi.hudText[DuelStatus].Draw(win, pixel.IM.Moved(duelPos.Sub(pixel.V(duelWidth/2, 0))).Scaled(duelPos, 2), "%v", status)
+	}
+
+	// Results screen
+	if Match.State == models.MatchEnded && Match.Result != nil {
+		resultsInfo := imdraw.New(nil)
+		resultsInfo.Color = color.RGBA{5, 10, 30, 140}
+		centerBasedPos := cam.Unproject(win.Bounds().Center())
+		resultsInfo.Push(
+			getRectangleVecs(centerBasedPos.Add(pixel.V(-180, -160)), pixel.V(360, 320))...,
+		)
+		resultsInfo.Rectangle(0)
+		resultsInfo.Draw(win)
+		pi.hudText[MatchResults].Draw(win, pixel.IM.Moved(centerBasedPos.Add(pixel.V(-160, 140))), "%v", matchResults())
+	}
+
 	// Zoom Button
 	if win.JustPressed(pixelgl.MouseButtonLeft) {
 		ix, iy := zoomTogglePos.Add(pixel.V(10, 10)).XY()
@@ -541,6 +606,64 @@ func (pi *PlayerInfo) Draw(win *pixelgl.Window, cam pixel.Matrix, cursor *Cursor
 	}
 }
 
+// matchStatus is the text of the match timer
+func matchStatus() string {
+	mode := tr("mode." + Match.Mode)
+	left := int(math.Ceil(Match.Remaining))
+	switch Match.State {
+	case models.MatchCountdown:
+		return tr("match.countdown", mode, left)
+	case models.MatchRunning:
+		return tr("match.running", mode, left/60, left%60)
+	case models.MatchEnded:
+		return tr("match.over")
+	}
+	return tr("match.waiting")
+}
+
+// spectatorInfo is the watched player stats and the spectator keys
+func (pi *PlayerInfo) spectatorInfo() string {
+	info := tr("spectate.free")
+	if t := Spectate.Target(pi.playersData); t != nil {
+		info = tr("spectate.watching", strings.TrimSpace(t.sname), className(t.wizard.Type), int(t.hp), int(t.maxhp), int(t.mp), int(t.maxmp))
+	}
+	return info + "\n" + tr("spectate.keys")
+}
+
+// matchResults is the text of the end of match screen
+func matchResults() string {
+	r := Match.Result
+	winner := tr("match.draw")
+	b := strings.Builder{}
+	b.WriteString(tr("match.over") + "\n\n")
+	if len(r.TeamScores) > 0 {
+		b.WriteString(tr("match.team_scores", r.TeamScores[models.RedTeam], r.TeamScores[models.BlueTeam]) + "\n")
+		switch r.WinnerTeam {
+		case models.RedTeam:
+			winner = tr("team.red")
+		case models.BlueTeam:
+			winner = tr("team.blue")
+		}
+	} else if r.WinnerName != "" {
+		winner = strings.TrimSpace(r.WinnerName)
+	}
+	b.WriteString(tr("match.winner", winner) + "\n\n")
+	for i := 0; i < len(r.Standings) && i < 10; i++ {
+		p := r.Standings[i]
+		fmt.Fprintf(&b, "%v   | %v| %v|\n", PadRight(fmt.Sprintf("%v: %v", i+1, rankingName(*p)), " ", 23), PadRight(fmt.Sprint(p.K), " ", 4), PadRight(fmt.Sprint(p.D), " ", 4))
+	}
+	b.WriteString("\n" + tr("match.next", int(math.Ceil(Match.Remaining))))
+	return b.String()
+}
+
+// rankingName is the name shown in rankings, bots are tagged
+func rankingName(p models.RankingPosMsg) string {
+	if p.Bot {
+		return tr("hud.bot_tag", strings.TrimSpace(p.Name))
+	}
+	return strings.TrimSpace(p.Name)
+}
+
 func PadRight(str, pad string, lenght int) string {
 	for {
 		str += pad
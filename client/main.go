@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"math"
+	"strings"
 	"time"
 
 	_ "image/png"
@@ -13,7 +15,10 @@ import (
 	"github.com/faiface/pixel/pixelgl"
 	"github.com/faiface/pixel/text"
 	"github.com/juanefec/go-pixel-ao/client/socket"
+	"github.com/juanefec/go-pixel-ao/locale"
+	"github.com/juanefec/go-pixel-ao/logging"
 	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
 	"golang.org/x/image/colornames"
 	"golang.org/x/image/font/basicfont"
 )
@@ -45,8 +50,12 @@ var (
 	FlashChargeInterval   = time.Second.Seconds() * 6
 
 	ArrowMaxCharge = time.Second.Seconds() * 2.5
+	// Rules
+	FriendlyFire = false
 	// Ranking
 	Ranking = []models.RankingPosMsg{}
+	// Match
+	Match = models.MatchMsg{}
 )
 var (
 	Newline   = []byte{'\n'}
@@ -78,9 +87,48 @@ var basicAtlas = text.NewAtlas(basicfont.Face7x13, text.ASCII)
 var chatlog = NewChatlog()
 
 func main() {
+	flag.StringVar(&ReplayPath, "replay", "", "replay file to watch instead of playing")
+	flag.StringVar(&ReplayPOV, "replay-pov", "", "player of the replay to watch it as, P switches while watching")
+	flag.StringVar(&FlagSettings.Server, "server", "", "server address, host:port")
+	flag.StringVar(&FlagSettings.Master, "master", "", "url of the master server the browser lists servers from")
+	flag.StringVar(&FlagSettings.Name, "name", "", "nickname, with -class it skips the login")
+	flag.StringVar(&FlagSettings.Class, "class", "", "wizard class: monk, hunter, sniper, pyro, shaman or jumper")
+	flag.StringVar(&FlagSettings.Room, "room", "", "room to join when skipping the login")
+	flag.StringVar(&BypassToken, "bypass-token", "", "bypass token of the server, to get in full rooms")
+	flag.Float64Var(&FlagSettings.Width, "width", 0, "window width")
+	flag.Float64Var(&FlagSettings.Height, "height", 0, "window height")
+	flag.StringVar(&ProfilePath, "profile", ProfilePath, "file where the last values used are remembered, empty to not remember them")
+	logLevel := flag.String("log-level", "info", "least important logs written: debug, info, warn or error")
+	logFile := flag.String("log-file", "./client.log", "file the logs go to, rotated when it grows")
+	flag.Parse()
+	closeLog := setupLogging(*logLevel, *logFile)
+	defer closeLog()
 	pixelgl.Run(run)
 }
 
+const (
+	LogMaxSize = 1 << 20
+	LogBackups = 3
+)
+
+// setupLogging sends the logs to a rotating file, or to stderr if the file
+// can't be opened
+func setupLogging(level, path string) func() {
+	o := logging.Options{Level: level}
+	f, err := logging.OpenRotatingFile(path, LogMaxSize, LogBackups)
+	if err == nil {
+		o.Output = f
+	}
+	if _, err := logging.Setup(o); err != nil {
+		logging.Fatal("Bad logging flags", "err", err)
+	}
+	if f == nil {
+		slog.Warn("Logging to stderr", "path", path, "err", err)
+		return func() {}
+	}
+	return func() { f.Close() }
+}
+
 //message order [id;name;playerX;playerY;dir;moving]
 
 func run() {
@@ -158,11 +206,32 @@ func run() {
 		panic(err)
 	}
 
-	ld, err := LoginWindow()
+	settings, err := loadSettings("./settings.json")
+	if err != nil {
+		slog.Warn("Loading settings", "err", err)
+	}
+	settings = settings.Merge(FlagSettings)
+	Lang = locale.Parse(settings.Locale)
+	profile, err := loadProfile(ProfilePath)
 	if err != nil {
-		log.Fatal(err)
+		slog.Warn("Loading profile", "err", err)
 	}
+	// the profile only fills in what wasn't chosen, it never skips the
+	// login or the server browser
+	quick := settings.QuickJoin()
+	browse := settings.Server == ""
+	settings = profile.Merge(settings).withDefaults()
+	ProfileID = settings.Profile
+
+	socket, replay, ld := connect(settings, browse, quick)
+	defer socket.Close()
+
 	player := NewPlayer(ld.Name, &ld)
+	var replayControls *ReplayControls
+	if replay != nil {
+		player.SetSpectator(true)
+		replayControls = NewReplayControls(replay)
+	}
 	allSpells := SpellKinds{
 		OnTarget: GameSpells{
 			NewSpellData("apoca", &player),
@@ -204,13 +273,10 @@ func run() {
 	playerInfo := NewPlayerInfo(&player, &otherPlayers, allSpells)
 	resu := NewResu(pixel.V(2000, 2900))
 
-	socket := socket.NewSocket("190.247.147.18", 33333)
-	defer socket.Close()
-
 	cfg := pixelgl.WindowConfig{
 		Title: "Creative AO",
 		//Monitor: pixelgl.PrimaryMonitor(),
-		Bounds: pixel.R(0, 0, 1360, 840),
+		Bounds: pixel.R(0, 0, settings.Width, settings.Height),
 		Icon:   []pixel.Picture{Pictures["./images/gameIcon.png"]},
 		//VSync:  true,
 	}
@@ -228,21 +294,31 @@ func run() {
 	newCenter := pixel.ZV
 	for !win.Closed() {
 		win.Clear(colornames.Forestgreen)
-		cam := pixel.IM.Scaled(player.pos, Zoom).Moved(win.Bounds().Center().Sub(player.pos))
+		focus := player.pos
+		spectating := Spectating(&player)
+		if replayControls != nil {
+			replayControls.Update(win)
+		}
+		if spectating {
+			Spectate.Update(win, &player, &otherPlayers)
+			focus = Spectate.Focus(&player, &otherPlayers)
+		}
+		cam := pixel.IM.Scaled(focus, Zoom).Moved(win.Bounds().Center().Sub(focus))
 
 		player.cam = cam
 
-		if win.Pressed(pixelgl.KeyLeftShift) {
+		// flying spectators keep the dragged offset without holding shift
+		if win.Pressed(pixelgl.KeyLeftShift) || (spectating && Spectate.FreeFly) {
 
 			if win.Pressed(pixelgl.MouseButtonRight) {
 
 				if !unatachedCam {
 					unatachedCam = true
-					offset = cam.Unproject(win.MousePosition()).Sub(player.pos)
+					offset = cam.Unproject(win.MousePosition()).Sub(focus)
 					newCenter = cam.Unproject(win.MousePosition()).Sub(newCenter).Sub(offset)
 
 				}
-				newCenter = cam.Unproject(win.MousePosition()).Sub(player.pos).Sub(offset)
+				newCenter = cam.Unproject(win.MousePosition()).Sub(focus).Sub(offset)
 			} else {
 				unatachedCam = false
 			}
@@ -257,15 +333,21 @@ func run() {
 		resu.Draw(win, cam, &player)
 		otherPlayers.Draw(win, &player)
 		player.Draw(win, socket)
+		DrawFlags(win, socket, &otherPlayers, &player)
 		player.DrawIngameHud(win, allSpells.ChargedProjectile[0])
 		//buda.Draw(win)
 		forest.Batch.Draw(win)
 		forest.Trees.Draw(win)
 		forest.FenceBatchV.Draw(win)
 		forest.FenceBatchHBOT.Draw(win)
+		DrawZone(win)
+		DrawHill(win)
 		allSpells.Draw(win, cam, socket, &otherPlayers, cursor)
 		playerInfo.Draw(win, cam, cursor, &ld)
 		chatlog.Draw(win, cam)
+		if replayControls != nil {
+			replayControls.Draw(win, cam)
+		}
 		cursor.Draw(cam, player.pos)
 
 		fps++
@@ -322,11 +404,16 @@ func GameUpdate(s *socket.Socket, pd *PlayersData, p *Player, spells SpellKinds)
 
 				players := []*models.PlayerMsg{}
 				json.Unmarshal(msg.Payload, &players)
+				me := p
 
 				for i := 0; i <= len(players)-1; i++ {
 
 					p := players[i]
-					if p.ID != s.ClientID {
+					if p.ID == s.ClientID {
+						me.SetTeam(p.Team)
+						me.duel, me.duelFighting = p.Duel, p.DuelFighting
+						me.SetSpectator(p.Spectator)
+					} else {
 						pd.AnimationsMutex.Lock()
 						player, ok := pd.CurrentAnimations[p.ID]
 						if !ok {
@@ -334,7 +421,11 @@ func GameUpdate(s *socket.Socket, pd *PlayersData, p *Player, spells SpellKinds)
 							wiz := Wizard{
 								Skin: SkinType(p.Skin),
 							}
-							np := NewPlayer(p.Name, &wiz)
+							name := p.Name
+							if p.Bot {
+								name = tr("hud.bot_tag", strings.TrimSpace(p.Name))
+							}
+							np := NewPlayer(name, &wiz)
 							pd.CurrentAnimations[p.ID] = &np
 							player, _ = pd.CurrentAnimations[p.ID]
 						}
@@ -344,7 +435,12 @@ func GameUpdate(s *socket.Socket, pd *PlayersData, p *Player, spells SpellKinds)
 						player.moving = p.Moving
 						player.dead = p.Dead
 						player.hp = p.HP
+						player.mp = p.MP
+						player.wizard.Type = WizardType(p.Class)
+						player.spectator = p.Spectator
 						player.invisible = p.Invisible
+						player.SetTeam(p.Team)
+						player.duel, player.duelFighting = p.Duel, p.DuelFighting
 					}
 				}
 				break
@@ -379,6 +475,9 @@ func GameUpdate(s *socket.Socket, pd *PlayersData, p *Player, spells SpellKinds)
 							newSpell.step = sd.Frames[0]
 							newSpell.frame = pixel.NewSprite(*(sd.Pic), newSpell.step)
 							sd.CurrentAnimations = append(sd.CurrentAnimations, newSpell)
+							if !SpellAffects(sd.SpellName, pd.Get(spell.ID, s.ClientID, p), target) {
+								break
+							}
 							target.hp -= sd.Damage
 							if target.hp <= 0 {
 								target.hp = 0
@@ -506,6 +605,60 @@ func GameUpdate(s *socket.Socket, pd *PlayersData, p *Player, spells SpellKinds)
 						p.deaths = Ranking[i].D
 					}
 				}
+			case models.Flags:
+				flags := []models.FlagMsg{}
+				json.Unmarshal(msg.Payload, &flags)
+				Flags = flags
+			case models.Match:
+				matchMsg := models.MatchMsg{}
+				json.Unmarshal(msg.Payload, &matchMsg)
+				// rounds without respawn start with everybody alive
+				if matchMsg.NoRespawn && Match.State != models.MatchRunning && p.dead && !p.spectator {
+					p.dead = false
+					p.hp = p.maxhp
+					p.mp = p.maxmp
+				}
+				Match = matchMsg
+			case models.Zone:
+				zoneMsg := models.ZoneMsg{}
+				json.Unmarshal(msg.Payload, &zoneMsg)
+				Zone = zoneMsg
+			case models.Hill:
+				hillMsg := models.HillMsg{}
+				json.Unmarshal(msg.Payload, &hillMsg)
+				Hill = hillMsg
+			case models.Duel:
+				duelMsg := models.DuelMsg{}
+				json.Unmarshal(msg.Payload, &duelMsg)
+				UpdateDuel(p, duelMsg)
+			case models.Damage:
+				dmg := models.DamageMsg{}
+				json.Unmarshal(msg.Payload, &dmg)
+				if !p.dead {
+					p.hp -= dmg.Amount
+					if p.hp <= 0 {
+						p.hp = 0
+						p.dead = true
+						dm := models.DeathMsg{
+							Killed:     s.ClientID,
+							KilledName: p.wizard.Name,
+							Killer:     ksuid.Nil,
+							KillerName: dmg.Reason,
+						}
+						SendDeathEvent(s, dm)
+					}
+				}
+			case models.Rules:
+				rules := models.RulesMsg{}
+				json.Unmarshal(msg.Payload, &rules)
+				FriendlyFire = rules.FriendlyFire
+			case models.System:
+				sysMsg := models.SystemMsg{}
+				json.Unmarshal(msg.Payload, &sysMsg)
+				chatlog.Load(ksuid.Nil, tr("chat.server"), sysMsg.Text, time.Now())
+			case models.Ping:
+				// echoed as is, the server measures the round trip
+				s.O <- models.NewMesg(models.Ping, msg.Payload)
 			case models.Disconect:
 				m := models.DisconectMsg{}
 				json.Unmarshal(msg.Payload, &m)
@@ -3,7 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"math"
+	"image/color"
 	"time"
 
 	"github.com/faiface/pixel"
@@ -12,6 +12,7 @@ import (
 	"github.com/faiface/pixel/text"
 	"github.com/juanefec/go-pixel-ao/client/socket"
 	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
 	"golang.org/x/image/colornames"
 )
 
@@ -49,6 +50,11 @@ type Player struct {
 	playerMovementSpeed                                                       float64
 	colliding                                                                 bool
 	collitionDir                                                              string
+	team                                                                      models.Team
+	classColor                                                                color.Color
+	duel                                                                      ksuid.KSUID
+	duelFighting                                                              bool
+	spectator                                                                 bool
 }
 
 func NewPlayer(name string, wizard *Wizard) Player {
@@ -119,6 +125,7 @@ func NewPlayer(name string, wizard *Wizard) Player {
 
 	p.wizard = wizard
 
+	p.classColor = p.name.Color
 	fmt.Fprintln(p.name, name)
 	bodyFrames = getFrames(bodySheet, 25, 45, 6, 4)
 
@@ -158,6 +165,33 @@ func NewPlayer(name string, wizard *Wizard) Player {
 	return *p
 }
 
+// SetTeam changes the player team and paints the nameplate with its colour
+func (p *Player) SetTeam(t models.Team) {
+	if p.team == t {
+		return
+	}
+	p.team = t
+	p.name.Clear()
+	p.name.Color = p.classColor
+	if t != models.NoTeam {
+		p.name.Color = TeamColor(t)
+	}
+	fmt.Fprintln(p.name, p.sname)
+}
+
+// TeamColor is the colour used to paint team nameplates and flags
+func TeamColor(t models.Team) color.Color {
+	if t == models.BlueTeam {
+		return colornames.Dodgerblue
+	}
+	return colornames.Red
+}
+
+// IsAlly reports if o is the same player or a team mate
+func (p *Player) IsAlly(o *Player) bool {
+	return p == o || (p.team != models.NoTeam && p.team == o.team)
+}
+
 func (p *Player) DrawHealthMana(win *pixelgl.Window) {
 	infoPos := p.pos.Add(pixel.V(-16, -24))
 	info := imdraw.New(nil)
@@ -291,7 +325,7 @@ func (p *Player) OnTrap(click pixel.Vec) bool {
 }
 
 func (p *Player) InsideRaduis(center pixel.Vec, r float64) bool {
-	return math.Abs(Dist(center, p.pos)) <= r
+	return models.InsideRadius(center.X, center.Y, p.pos.X, p.pos.Y, r)
 }
 
 func (p *Player) clientUpdate(s *socket.Socket) {
@@ -306,6 +340,8 @@ func (p *Player) clientUpdate(s *socket.Socket) {
 		Moving:    p.moving,
 		Dead:      p.dead,
 		Invisible: p.invisible,
+		MP:        p.mp,
+		Class:     int(p.wizard.Type),
 	}
 	playerMsg, err := json.Marshal(p.playerUpdate)
 	if err != nil {
@@ -318,7 +354,7 @@ func (p *Player) clientUpdate(s *socket.Socket) {
 
 func (p *Player) Update(pl *Player) {
 	if !p.dead {
-		if pl != nil {
+		if pl != nil && !p.spectator && !pl.spectator {
 			pl.CollidingCheck(p.pos)
 		}
 		switch p.dir {
@@ -422,6 +458,9 @@ func (p *Player) Draw(win *pixelgl.Window, s *socket.Socket) {
 		p.chat.Write(win)
 	}
 	p.chat.Draw(win, p.pos)
+	if p.spectator {
+		return
+	}
 	p.name.Draw(win, p.nameMatrix)
 	if !p.invisible {
 		p.body.Draw(win, p.bodyMatrix)
@@ -101,11 +101,26 @@ func NewPlayersData() PlayersData {
 	return pd
 }
 
+// Get returns the player with id, the local player when id is localID
+// and nil when it is unknown
+func (pd *PlayersData) Get(id, localID ksuid.KSUID, local *Player) *Player {
+	if id == localID {
+		return local
+	}
+	pd.AnimationsMutex.RLock()
+	defer pd.AnimationsMutex.RUnlock()
+	return pd.CurrentAnimations[id]
+}
+
 func (pd *PlayersData) Draw(win *pixelgl.Window, pl *Player) {
 	pd.Skins.BatchClear()
 	pd.AnimationsMutex.RLock()
 	for _, p := range pd.CurrentAnimations {
 		pd.AnimationsMutex.RUnlock()
+		if p.spectator {
+			pd.AnimationsMutex.RLock()
+			continue
+		}
 		pd.Skins.DrawToBatch(p, pl)
 		if !p.invisible {
 			p.name.Draw(win, p.nameMatrix.Moved(pixel.V(0, 4)))
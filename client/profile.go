@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/client/socket"
+	"github.com/juanefec/go-pixel-ao/logging"
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+// QuickJoinTimeout is how long the server has to answer a quick join before
+// the login window opens
+const QuickJoinTimeout = 5 * time.Second
+
+// ProfilePath is where the last server, name, class, room and window size
+// used are remembered, empty to not remember them
+var ProfilePath = defaultProfilePath()
+
+func defaultProfilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "./profile.json"
+	}
+	return filepath.Join(dir, "go-pixel-ao", "profile.json")
+}
+
+// loadProfile reads the last values used, a missing profile is empty
+func loadProfile(path string) (Settings, error) {
+	p := Settings{}
+	if path == "" {
+		return p, nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(raw, &p)
+	return p, err
+}
+
+func saveProfile(path string, p Settings) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	p.Locale = ""
+	raw, _ := json.MarshalIndent(p, "", "    ")
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+// classNames are the wizard classes by the names the login shows, the
+// server names of bots work too
+var classNames = map[string]WizardType{
+	"monk":       Monk,
+	"hunter":     Hunter,
+	"sniper":     Sniper,
+	"pyro":       DarkWizard,
+	"darkwizard": DarkWizard,
+	"shaman":     Shaman,
+	"jumper":     Timewreker,
+	"timewreker": Timewreker,
+}
+
+func ParseClass(name string) (WizardType, bool) {
+	t, ok := classNames[strings.ToLower(strings.TrimSpace(name))]
+	return t, ok
+}
+
+// ClassName is the name the login shows for t
+func ClassName(t WizardType) string {
+	switch t {
+	case Monk:
+		return "monk"
+	case Hunter:
+		return "hunter"
+	case Sniper:
+		return "sniper"
+	case DarkWizard:
+		return "pyro"
+	case Shaman:
+		return "shaman"
+	case Timewreker:
+		return "jumper"
+	}
+	return ""
+}
+
+// NewWizard is the wizard of class t the login hands out
+func NewWizard(t WizardType, name string) Wizard {
+	w := Wizard{Name: name, Type: t}
+	switch t {
+	case Monk:
+		w.Skin, w.SpecialSpells = BlueBody, []string{"healshot", "heal-spot"}
+	case Hunter:
+		w.Skin, w.SpecialSpells = RedBody, []string{"arrowshot", "bear-trap"}
+	case Sniper:
+		w.Skin, w.SpecialSpells = BlueArmorBody, []string{"icesnipe", "smoke-spot"}
+	case DarkWizard:
+		w.Skin, w.SpecialSpells = DarkMasterBody, []string{"fireball", "lava-spot"}
+	case Shaman:
+		w.Skin, w.SpecialSpells = TuniDruida, []string{"manashot", "mana-spot"}
+	case Timewreker:
+		w.Skin, w.SpecialSpells = TwilightBody, []string{"rockshot", "flash"}
+	}
+	return w
+}
+
+// quickJoin joins the room of st without the login window, it returns the
+// error the server gave so the login can open instead
+func quickJoin(s *socket.Socket, st Settings) (Wizard, error) {
+	class, _ := ParseClass(st.Class)
+	joinRoom(s, models.JoinRoomMsg{Room: st.Room})
+	timeout := time.After(QuickJoinTimeout)
+	for {
+		select {
+		case data := <-s.I:
+			msg := models.UnmarshallMesg(data)
+			if msg.Type != models.JoinRoom {
+				continue
+			}
+			reply := models.JoinRoomMsg{}
+			json.Unmarshal(msg.Payload, &reply)
+			if reply.Error != "" {
+				return Wizard{}, errors.New(reply.Error)
+			}
+			return NewWizard(class, st.Name), nil
+		case <-timeout:
+			return Wizard{}, errors.New(tr("login.join_timeout"))
+		}
+	}
+}
+
+// connect opens the replay when one was given, or goes through the login:
+// the server browser unless the server was chosen, and straight into the
+// room when quick. What was used is remembered in the profile.
+func connect(st Settings, browse, quick bool) (*socket.Socket, *socket.Replay, Wizard) {
+	if ReplayPath != "" {
+		return openReplay()
+	}
+	var s *socket.Socket
+	if !browse || quick {
+		host, port, err := net.SplitHostPort(st.Server)
+		if err != nil {
+			logging.Fatal("Bad server address", "server", st.Server, "err", err)
+		}
+		portNumber, err := strconv.Atoi(port)
+		if err != nil {
+			logging.Fatal("Bad server port", "server", st.Server, "err", err)
+		}
+		s = socket.NewSocket(host, portNumber, handshake())
+	}
+	if quick {
+		ld, err := quickJoin(s, st)
+		if err == nil {
+			rememberProfile(st, Login{Server: st.Server, Wizard: ld, Room: st.Room, Bookmarks: st.Bookmarks})
+			return s, nil, ld
+		}
+		slog.Warn("Quick join failed, opening the login", "room", st.Room, "err", err)
+	}
+	login, err := LoginWindow(s, st)
+	if err != nil {
+		logging.Fatal("Login", "err", err)
+	}
+	rememberProfile(st, login)
+	return login.Socket, nil, login.Wizard
+}
+
+func rememberProfile(st Settings, l Login) {
+	st.Server, st.Name, st.Class, st.Room = l.Server, l.Wizard.Name, ClassName(l.Wizard.Type), l.Room
+	st.Bookmarks = l.Bookmarks
+	if err := saveProfile(ProfilePath, st); err != nil {
+		slog.Warn("Saving profile", "path", ProfilePath, "err", err)
+	}
+}
@@ -1,24 +1,54 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
 	"github.com/faiface/pixel/text"
 	"github.com/golang/image/colornames"
+	"github.com/juanefec/go-pixel-ao/client/socket"
+	"github.com/juanefec/go-pixel-ao/models"
 	"golang.org/x/image/font/basicfont"
 )
 
 type LoginStep int
 
 const (
-	Name LoginStep = iota
+	ChooseServer LoginStep = iota
+	Name
 	ChooseWizard
+	ChooseRoom
 )
 
-func LoginWindow() (Wizard, error) {
+// roomModes are the modes a new room can be created with
+var roomModes = []string{"ffa", "tdm", "ctf", "br", "koth", "tkoth"}
+
+// Login is what the login window ends with
+type Login struct {
+	Socket    *socket.Socket
+	Server    string
+	Wizard    Wizard
+	Room      string
+	Bookmarks []string
+}
+
+// dialResult is a connection made in the server browser
+type dialResult struct {
+	s    *socket.Socket
+	addr string
+	err  error
+}
+
+// LoginWindow asks for the server when s is nil, then the nickname, the
+// wizard and the room to join. The nickname starts as the one in st, the
+// browser lists the bookmarks in st and the servers on the LAN.
+func LoginWindow(s *socket.Socket, st Settings) (Login, error) {
 
 	atlas := text.NewAtlas(basicfont.Face7x13, text.ASCII)
 	nickname := text.New(pixel.V(50, 100), atlas)
@@ -26,11 +56,11 @@ func LoginWindow() (Wizard, error) {
 
 	txt := text.New(pixel.V(0, 0), atlas)
 	txt.Color = colornames.Lightgray
-	txt.WriteString("Enter nickname:\n")
+	txt.WriteString(tr("login.enter_nickname"))
 
 	choose := text.New(pixel.V(0, 0), atlas)
 	choose.Color = colornames.Darkgray
-	choose.WriteString("Choose wizard:\n")
+	choose.WriteString(tr("login.choose_wizard"))
 
 	redSkin := Pictures["./images/bodyRedIcon.png"]
 	redIcon := pixel.NewSprite(redSkin, redSkin.Bounds())
@@ -52,27 +82,27 @@ func LoginWindow() (Wizard, error) {
 
 	monkName := text.New(pixel.V(0, 0), atlas)
 	monkName.Color = colornames.Cyan
-	fmt.Fprint(monkName, "Monk")
+	fmt.Fprint(monkName, tr("class.monk"))
 
 	hunterName := text.New(pixel.V(0, 0), atlas)
 	hunterName.Color = colornames.Red
-	fmt.Fprint(hunterName, "Hunter")
+	fmt.Fprint(hunterName, tr("class.hunter"))
 
 	sniperName := text.New(pixel.V(0, 0), atlas)
 	sniperName.Color = colornames.Blue
-	fmt.Fprint(sniperName, "Sniper")
+	fmt.Fprint(sniperName, tr("class.sniper"))
 
 	darkName := text.New(pixel.V(0, 0), atlas)
 	darkName.Color = colornames.Darkgray
-	fmt.Fprint(darkName, "Pyro")
+	fmt.Fprint(darkName, tr("class.pyro"))
 
 	shamanName := text.New(pixel.V(0, 0), atlas)
 	shamanName.Color = colornames.Whitesmoke
-	fmt.Fprint(shamanName, "Shaman")
+	fmt.Fprint(shamanName, tr("class.shaman"))
 
 	jumperName := text.New(pixel.V(0, 0), atlas)
 	jumperName.Color = colornames.Darkgoldenrod
-	fmt.Fprint(jumperName, "Jumper")
+	fmt.Fprint(jumperName, tr("class.jumper"))
 
 	cfg := pixelgl.WindowConfig{
 		Title:  "Creative AO | Login",
@@ -87,10 +117,116 @@ func LoginWindow() (Wizard, error) {
 
 	fps := time.Tick(time.Second / 120)
 
-	nn := ""
+	roomsTxt := text.New(pixel.V(150, 430), atlas)
+	roomsTxt.LineHeight = 26
+	newRoomTxt := text.New(pixel.V(150, 120), atlas)
+	newRoomTxt.Color = colornames.Lightgrey
+	roomError := text.New(pixel.V(150, 60), atlas)
+	roomError.Color = colornames.Red
+
+	nn := st.Name
+	nickname.WriteString(nn)
 	loginStep := Name
+	login := Login{Socket: s, Server: st.Server, Bookmarks: append([]string{}, st.Bookmarks...)}
+	var browser *Browser
+	if s == nil {
+		loginStep = ChooseServer
+		browser = NewBrowser(addBookmark(append([]string{}, st.Bookmarks...), st.Server), st.Master)
+		defer browser.Stop()
+	}
+	serverTitle := text.New(pixel.V(0, 0), atlas)
+	serverTitle.Color = colornames.Darkgray
+	serverTitle.WriteString(tr("browser.choose_server"))
+	serversTxt := text.New(pixel.V(60, 430), atlas)
+	serversTxt.LineHeight = 26
+	serverAddr := ""
+	typedAddr := ""
+	dials := make(chan dialResult, 1)
+	dialing := false
+	dial := func(addr string) {
+		if dialing {
+			return
+		}
+		dialing = true
+		roomError.Clear()
+		roomError.WriteString(tr("browser.connecting", addr))
+		go func() {
+			s, err := socket.Dial(addr, handshake())
+			dials <- dialResult{s, addr, err}
+		}()
+	}
+	wizard := Wizard{}
+	pick := func(w Wizard) {
+		wizard = w
+		loginStep = ChooseRoom
+		s.O <- models.NewMesg(models.Lobby, nil)
+	}
+	rooms := []models.RoomMsg{}
+	newRoom := ""
+	newMode := 0
+	refresh := time.Tick(2 * time.Second)
 	for !win.Closed() {
 		win.Clear(colornames.Black)
+		// the room step starts on the frame after the wizard click
+		step := loginStep
+
+		if loginStep == ChooseServer {
+			select {
+			case d := <-dials:
+				dialing = false
+				roomError.Clear()
+				if d.err != nil {
+					roomError.WriteString(tr("browser.failed", d.addr))
+					break
+				}
+				s, login.Socket, login.Server = d.s, d.s, d.addr
+				// a typed address is bookmarked once it connects
+				if d.addr == typedAddr {
+					login.Bookmarks = addBookmark(login.Bookmarks, d.addr)
+				}
+				browser.Stop()
+				loginStep = Name
+			default:
+			}
+
+			servers := browser.Servers()
+			serverAddr += win.Typed()
+			if (win.JustPressed(pixelgl.KeyBackspace) || win.Repeated(pixelgl.KeyBackspace)) && serverAddr != "" {
+				serverAddr = serverAddr[:len(serverAddr)-1]
+			}
+			if win.JustPressed(pixelgl.KeyEnter) && serverAddr != "" && !dialing {
+				typedAddr = serverAddr
+				dial(serverAddr)
+			}
+			if win.JustPressed(pixelgl.MouseButtonLeft) {
+				x, y := win.MousePosition().XY()
+				row := int(math.Floor((serversTxt.Orig.Y + serversTxt.LineHeight*0.75 - y) / serversTxt.LineHeight))
+				if x > 50 && x < 860 && row >= 0 && row < len(servers) {
+					e := servers[row]
+					if e.Info != nil && e.Info.Version != models.ProtocolVersion {
+						roomError.Clear()
+						roomError.WriteString(tr("browser.incompatible"))
+					} else {
+						dial(e.Addr)
+					}
+				}
+			}
+
+			serversTxt.Clear()
+			for _, e := range servers {
+				fmt.Fprintln(serversTxt, e)
+			}
+			newRoomTxt.Clear()
+			fmt.Fprint(newRoomTxt, tr("browser.address", serverAddr))
+
+			serverTitle.Draw(win, pixel.IM.Moved(pixel.V(60, 480)).Scaled(pixel.V(60, 480), 2))
+			serversTxt.Draw(win, pixel.IM)
+			newRoomTxt.Draw(win, pixel.IM)
+			roomError.Draw(win, pixel.IM)
+			win.Update()
+			<-fps
+			continue
+		}
 
 		if loginStep == Name {
 			nickname.WriteString(win.Typed())
@@ -106,14 +242,15 @@ func LoginWindow() (Wizard, error) {
 			}
 			if win.JustPressed(pixelgl.KeyEnter) || win.Repeated(pixelgl.KeyEnter) {
 				if nn == "   creagod   " {
-					return Wizard{
+					pick(Wizard{
 						Skin:          GodBody,
 						Name:          nn,
 						Type:          Sniper,
 						SpecialSpells: []string{"icesnipe", "smoke-spot"},
-					}, nil
+					})
+				} else {
+					loginStep = ChooseWizard
 				}
-				loginStep = ChooseWizard
 			}
 		}
 
@@ -149,64 +286,120 @@ func LoginWindow() (Wizard, error) {
 				x, y := win.MousePosition().XY()
 				halfdist := dist / 2
 				if x < dist+halfdist && x > dist-halfdist && y > 110 && y < 210 {
-					return Wizard{
-						Name:          nn,
-						Skin:          BlueBody,
-						Type:          Monk,
-						SpecialSpells: []string{"healshot", "heal-spot"},
-					}, nil
-
+					pick(NewWizard(Monk, nn))
 				}
 				if x < (dist*2)+halfdist && x > (dist*2)-halfdist && y > 110 && y < 210 {
-					return Wizard{
-						Skin:          RedBody,
-						Name:          nn,
-						Type:          Hunter,
-						SpecialSpells: []string{"arrowshot", "bear-trap"},
-					}, nil
+					pick(NewWizard(Hunter, nn))
 				}
 				if x < (dist*3)+halfdist && x > (dist*3)-halfdist && y > 110 && y < 210 {
-					return Wizard{
-						Skin:          BlueArmorBody,
-						Name:          nn,
-						Type:          Sniper,
-						SpecialSpells: []string{"icesnipe", "smoke-spot"},
-					}, nil
+					pick(NewWizard(Sniper, nn))
 				}
 				if x < (dist*4)+halfdist && x > (dist*4)-halfdist && y > 110 && y < 210 {
-					return Wizard{
-						Name:          nn,
-						Skin:          DarkMasterBody,
-						Type:          DarkWizard,
-						SpecialSpells: []string{"fireball", "lava-spot"},
-					}, nil
+					pick(NewWizard(DarkWizard, nn))
 				}
 				if x < (dist*5)+halfdist && x > (dist*5)-halfdist && y > 110 && y < 210 {
-					return Wizard{
-						Skin:          TuniDruida,
-						Name:          nn,
-						Type:          Shaman,
-						SpecialSpells: []string{"manashot", "mana-spot"},
-					}, nil
+					pick(NewWizard(Shaman, nn))
 				}
 				if x < (dist*6)+halfdist && x > (dist*6)-halfdist && y > 110 && y < 210 {
-					return Wizard{
-						Skin:          TwilightBody,
-						Name:          nn,
-						Type:          Timewreker,
-						SpecialSpells: []string{"rockshot", "flash"},
-					}, nil
-
+					pick(NewWizard(Timewreker, nn))
 				}
 			}
 		}
 
-		txt.Draw(win, pixel.IM.Moved(win.Bounds().Center().Sub(txt.Bounds().Center()).Add(pixel.V(0, 100))).Scaled(win.Bounds().Center(), 2))
-		nickname.Draw(win, pixel.IM.Moved(win.Bounds().Center().Sub(nickname.Bounds().Center()).Add(pixel.V(0, 70))).Scaled(win.Bounds().Center(), 2))
+		if step == ChooseRoom {
+			select {
+			case data := <-s.I:
+				msg := models.UnmarshallMesg(data)
+				switch msg.Type {
+				case models.Lobby:
+					json.Unmarshal(msg.Payload, &rooms)
+				case models.JoinRoom:
+					reply := models.JoinRoomMsg{}
+					json.Unmarshal(msg.Payload, &reply)
+					roomError.Clear()
+					if reply.Queued > 0 {
+						roomError.WriteString(tr("login.queued", reply.Room, reply.Queued))
+						break
+					}
+					if reply.Error == "" {
+						login.Wizard, login.Room = wizard, reply.Room
+						return login, nil
+					}
+					roomError.WriteString(reply.Error)
+				}
+			case <-refresh:
+				s.O <- models.NewMesg(models.Lobby, nil)
+			default:
+			}
+
+			newRoom += win.Typed()
+			if (win.JustPressed(pixelgl.KeyBackspace) || win.Repeated(pixelgl.KeyBackspace)) && newRoom != "" {
+				newRoom = newRoom[:len(newRoom)-1]
+			}
+			if win.JustPressed(pixelgl.KeyTab) {
+				newMode = (newMode + 1) % len(roomModes)
+			}
+			if win.JustPressed(pixelgl.KeyEnter) && newRoom != "" {
+				joinRoom(s, models.JoinRoomMsg{Room: strings.TrimSpace(newRoom), Mode: roomModes[newMode], Create: true})
+			}
+
+			roomsTxt.Clear()
+			for _, r := range rooms {
+				players := fmt.Sprint(r.Players)
+				if r.MaxPlayers > 0 {
+					players = fmt.Sprintf("%d/%d", r.Players, r.MaxPlayers)
+				}
+				row := tr("login.room", PadRight(r.Name, " ", 22), PadRight(tr("mode."+r.Mode), " ", 24), players)
+				if r.Queued > 0 {
+					row += "  " + tr("login.waiting", r.Queued)
+				}
+				fmt.Fprintln(roomsTxt, row)
+			}
+			newRoomTxt.Clear()
+			fmt.Fprint(newRoomTxt, tr("login.new_room", newRoom, tr("mode."+roomModes[newMode])))
+
+			if win.JustPressed(pixelgl.MouseButtonLeft) {
+				x, y := win.MousePosition().XY()
+				// rows are LineHeight apart going down from the text origin
+				row := int(math.Floor((roomsTxt.Orig.Y + roomsTxt.LineHeight*0.75 - y) / roomsTxt.LineHeight))
+				if x > 140 && x < 760 && row >= 0 && row < len(rooms) {
+					// a full room puts us in its queue
+					joinRoom(s, models.JoinRoomMsg{Room: rooms[row].Name, Queue: true})
+				}
+			}
+
+			choose.Clear()
+			choose.WriteString(tr("login.choose_room"))
+			choose.Draw(win, pixel.IM.Moved(pixel.V(150, 480)).Scaled(pixel.V(150, 480), 2))
+			roomsTxt.Draw(win, pixel.IM)
+			newRoomTxt.Draw(win, pixel.IM)
+			roomError.Draw(win, pixel.IM)
+		} else {
+			txt.Draw(win, pixel.IM.Moved(win.Bounds().Center().Sub(txt.Bounds().Center()).Add(pixel.V(0, 100))).Scaled(win.Bounds().Center(), 2))
+			nickname.Draw(win, pixel.IM.Moved(win.Bounds().Center().Sub(nickname.Bounds().Center()).Add(pixel.V(0, 70))).Scaled(win.Bounds().Center(), 2))
+		}
 		win.Update()
 		<-fps
 	}
-	return Wizard{}, fmt.Errorf("No se ingreso el nombre correctamente")
+	if login.Socket != nil {
+		login.Socket.Close()
+	}
+	return login, errors.New(tr("login.no_name"))
+}
+
+// addBookmark adds addr to bookmarks unless it is there already
+func addBookmark(bookmarks []string, addr string) []string {
+	for _, b := range bookmarks {
+		if b == addr {
+			return bookmarks
+		}
+	}
+	return append(bookmarks, addr)
+}
+
+func joinRoom(s *socket.Socket, req models.JoinRoomMsg) {
+	payload, _ := json.Marshal(req)
+	s.O <- models.NewMesg(models.JoinRoom, payload)
 }
 
 func inBody(skin SkinType) {}
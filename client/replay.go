@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"github.com/juanefec/go-pixel-ao/client/socket"
+	"github.com/juanefec/go-pixel-ao/logging"
+)
+
+const ReplaySeekStep = 10 * time.Second
+
+// ReplayPath is the replay file to watch instead of connecting to a server,
+// ReplayPOV the player it is seen by, the first one of the roster if empty
+var (
+	ReplayPath = ""
+	ReplayPOV  = ""
+)
+
+var replaySpeeds = []float64{0.25, 0.5, 1, 2, 4, 8}
+
+// openReplay opens ReplayPath, the socket plays it back to the game
+func openReplay() (*socket.Socket, *socket.Replay, Wizard) {
+	s, r, err := socket.OpenReplay(ReplayPath)
+	if err != nil {
+		logging.Fatal("Opening replay", "path", ReplayPath, "err", err)
+	}
+	if ReplayPOV != "" && !r.SetPOV(ReplayPOV) {
+		slog.Warn("Player not in the replay", "pov", ReplayPOV)
+	}
+	// the viewer is a spectator, the wizard only fills the hud
+	return s, r, Wizard{
+		Name:          tr("replay.viewer"),
+		Skin:          BlueBody,
+		Type:          Monk,
+		SpecialSpells: []string{"healshot", "heal-spot"},
+	}
+}
+
+// ReplayControls handles the playback keys: space pauses, + and - change
+// the speed, [ ] seek back and forth and P switches the player it is seen by
+type ReplayControls struct {
+	replay *socket.Replay
+	speed  int
+	status *text.Text
+}
+
+func NewReplayControls(r *socket.Replay) *ReplayControls {
+	return &ReplayControls{replay: r, speed: 2, status: text.New(pixel.ZV, basicAtlas)}
+}
+
+func (rc *ReplayControls) Update(win *pixelgl.Window) {
+	switch {
+	case win.JustPressed(pixelgl.KeySpace):
+		rc.replay.TogglePause()
+	case win.JustPressed(pixelgl.KeyEqual) && rc.speed < len(replaySpeeds)-1:
+		rc.speed++
+		rc.replay.SetSpeed(replaySpeeds[rc.speed])
+	case win.JustPressed(pixelgl.KeyMinus) && rc.speed > 0:
+		rc.speed--
+		rc.replay.SetSpeed(replaySpeeds[rc.speed])
+	case win.JustPressed(pixelgl.KeyRightBracket):
+		rc.replay.Seek(rc.replay.Position() + ReplaySeekStep)
+	case win.JustPressed(pixelgl.KeyLeftBracket):
+		rc.replay.Seek(rc.replay.Position() - ReplaySeekStep)
+	case win.JustPressed(pixelgl.KeyP):
+		rc.replay.NextPOV()
+	}
+}
+
+// Draw shows the playback position at the top of the screen
+func (rc *ReplayControls) Draw(win *pixelgl.Window, cam pixel.Matrix) {
+	pos, length := rc.replay.Position(), rc.replay.Length()
+	if pos > length {
+		pos = length
+	}
+	rc.status.Clear()
+	rc.status.WriteString(tr("replay.status", rc.replay.Header.Room, rc.replay.POVName(), clock(pos), clock(length), rc.replay.Speed()))
+	if rc.replay.Paused() {
+		rc.status.WriteString(tr("replay.paused"))
+	}
+	rc.status.WriteString("\n" + tr("replay.keys"))
+	statusPos := cam.Unproject(pixel.V(win.Bounds().W()/2, win.Bounds().H()-90))
+	rc.status.Draw(win, pixel.IM.Moved(statusPos.Sub(pixel.V(rc.status.Bounds().W()/2, 0))))
+}
+
+func clock(d time.Duration) string {
+	s := int(d.Seconds())
+	return tr("replay.clock", s/60, s%60)
+}
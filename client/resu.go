@@ -33,7 +33,7 @@ func (r *Resu) Draw(win *pixelgl.Window, cam pixel.Matrix, p *Player) {
 	// }
 	if win.JustPressed(pixelgl.MouseButtonRight) {
 		mouse := cam.Unproject(win.MousePosition())
-		if r.OnMe(mouse) && p.dead {
+		if r.OnMe(mouse) && p.dead && !p.spectator && !Match.NoRespawn {
 			p.dead = false
 			p.hp = p.maxhp
 			p.mp = p.maxmp
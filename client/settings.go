@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/juanefec/go-pixel-ao/locale"
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	DefaultServer       = "190.247.147.18:33333"
+	DefaultRoom         = "main"
+	DefaultWindowWidth  = 1360
+	DefaultWindowHeight = 840
+)
+
+// Settings are the user preferences read from settings.json, the flags
+// override them. The same fields are kept in the profile with the last
+// values used.
+type Settings struct {
+	Locale string  `json:"locale,omitempty"`
+	Server string  `json:"server,omitempty"` // host:port
+	Name   string  `json:"name,omitempty"`
+	Class  string  `json:"class,omitempty"` // monk, hunter, sniper, pyro, shaman or jumper
+	Room   string  `json:"room,omitempty"`
+	Width  float64 `json:"width,omitempty"`
+	Height float64 `json:"height,omitempty"`
+	// Master is the url of the master server the browser lists servers from
+	Master string `json:"master,omitempty"`
+	// Bookmarks are servers listed in the browser, host:port
+	Bookmarks []string `json:"bookmarks,omitempty"`
+	// Profile is a random id made once and sent in the handshake, servers
+	// give the ranking place back by it after a restart
+	Profile string `json:"profile,omitempty"`
+}
+
+// FlagSettings are the settings given as flags, they win over settings.json
+var FlagSettings = Settings{}
+
+// Lang is the locale every client string is translated to
+var Lang = locale.Default
+
+// BypassToken is sent in the handshake to get in full rooms, it is only
+// taken as a flag so it isn't kept in the profile
+var BypassToken = ""
+
+// ProfileID is the Profile of the settings in use
+var ProfileID = ""
+
+// handshake is what the client tells the server once connected
+func handshake() models.HandshakeMsg {
+	return models.HandshakeMsg{Locale: string(Lang), BypassToken: BypassToken, Profile: ProfileID}
+}
+
+func loadSettings(path string) (Settings, error) {
+	st := Settings{Locale: string(locale.Default)}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(raw, &st)
+	return st, err
+}
+
+// Merge returns s with the fields set in o replacing its own, bookmarks
+// are added up
+func (s Settings) Merge(o Settings) Settings {
+	if o.Locale != "" {
+		s.Locale = o.Locale
+	}
+	if o.Server != "" {
+		s.Server = o.Server
+	}
+	if o.Name != "" {
+		s.Name = o.Name
+	}
+	if o.Class != "" {
+		s.Class = o.Class
+	}
+	if o.Master != "" {
+		s.Master = o.Master
+	}
+	if o.Room != "" {
+		s.Room = o.Room
+	}
+	if o.Width > 0 {
+		s.Width = o.Width
+	}
+	if o.Height > 0 {
+		s.Height = o.Height
+	}
+	if o.Profile != "" {
+		s.Profile = o.Profile
+	}
+	bookmarks := append([]string{}, s.Bookmarks...)
+	for _, b := range o.Bookmarks {
+		bookmarks = addBookmark(bookmarks, b)
+	}
+	s.Bookmarks = bookmarks
+	return s
+}
+
+// withDefaults fills what is still missing
+func (s Settings) withDefaults() Settings {
+	return Settings{
+		Server: DefaultServer,
+		Room:   DefaultRoom,
+		Width:  DefaultWindowWidth,
+		Height: DefaultWindowHeight,
+		// a new profile until it is saved
+		Profile: ksuid.New().String(),
+	}.Merge(s)
+}
+
+// QuickJoin reports if there is enough to play without the login window
+func (s Settings) QuickJoin() bool {
+	_, ok := ParseClass(s.Class)
+	return s.Name != "" && ok
+}
+
+// tr translates a catalog key to the client locale
+func tr(key string, args ...interface{}) string {
+	return locale.T(Lang, key, args...)
+}
@@ -0,0 +1,286 @@
+package socket
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+// Events that only make sense to the recorded player, they are not played
+var replaySkipped = map[models.Event]bool{
+	models.Damage:   true,
+	models.Duel:     true,
+	models.Lobby:    true,
+	models.JoinRoom: true,
+	models.Ping:     true,
+}
+
+// Events that hold the whole state of something, on a seek the latest of
+// each is played again so the view is right at the new position
+var replaySnapshots = []models.Event{
+	models.UpdateClient,
+	models.UpdateRanking,
+	models.Rules,
+	models.Match,
+	models.Flags,
+	models.Zone,
+	models.Hill,
+}
+
+// Replay plays a recorded match into a Socket, as seen by one player of
+// the roster: what the server sent it plus its own spells and chat, that
+// other players got instead.
+type Replay struct {
+	Header  models.ReplayHeader
+	POV     ksuid.KSUID
+	all     []models.ReplayEntry
+	entries []models.ReplayEntry // the ones of POV
+	mutex   *sync.Mutex
+	next    int
+	seeked  bool // the state at next has to be played again
+	paused  bool
+	speed   float64
+	base    time.Duration // replay position at anchor
+	anchor  time.Time
+}
+
+// OpenReplay loads a replay file and returns a Socket fed by it, messages
+// written to the Socket are dropped
+func OpenReplay(path string) (*Socket, *Replay, error) {
+	r, err := LoadReplay(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &Socket{
+		Online: true,
+		// a viewer that isn't in the match, so every player is drawn
+		ClientID: ksuid.New(),
+		I:        make(chan []byte),
+		O:        make(chan []byte, 512),
+	}
+	go func() {
+		for range s.O {
+		}
+	}()
+	go r.play(s)
+	return s, r, nil
+}
+
+func LoadReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+
+	r := &Replay{mutex: &sync.Mutex{}, speed: 1, anchor: time.Now()}
+	if err := dec.Decode(&r.Header); err != nil {
+		return nil, err
+	}
+	if r.Header.Version != models.ProtocolVersion {
+		return nil, errors.New("replay was recorded with another protocol version")
+	}
+	if len(r.Header.Roster) == 0 {
+		return nil, errors.New("replay has no players")
+	}
+	for dec.More() {
+		e := models.ReplayEntry{}
+		if err := dec.Decode(&e); err != nil {
+			// a server that died mid match leaves the file cut short
+			break
+		}
+		r.all = append(r.all, e)
+	}
+	r.setPOV(0)
+	return r, nil
+}
+
+// SetPOV plays the replay as seen by the player of the roster with that
+// name from the current position, false if there is none
+func (r *Replay) SetPOV(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, p := range r.Header.Roster {
+		if p.Name == name {
+			r.setPOV(i)
+			return true
+		}
+	}
+	return false
+}
+
+// NextPOV switches to the next player of the roster and returns its name
+func (r *Replay) NextPOV() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	i := r.pov()
+	r.setPOV((i + 1) % len(r.Header.Roster))
+	return r.Header.Roster[r.pov()].Name
+}
+
+// POVName is the name of the player the replay is seen by
+func (r *Replay) POVName() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.Header.Roster[r.pov()].Name
+}
+
+func (r *Replay) pov() int {
+	for i, p := range r.Header.Roster {
+		if p.ID == r.POV {
+			return i
+		}
+	}
+	return 0
+}
+
+// setPOV keeps the entries of the roster player i, it runs under mutex
+func (r *Replay) setPOV(i int) {
+	pos := r.position()
+	r.POV = r.Header.Roster[i].ID
+	r.entries = r.entries[:0]
+	for _, e := range r.all {
+		if r.keep(e) {
+			r.entries = append(r.entries, e)
+		}
+	}
+	r.seek(pos)
+}
+
+// keep reports if an entry is part of what the POV player saw
+func (r *Replay) keep(e models.ReplayEntry) bool {
+	if e.Client != r.POV {
+		return false
+	}
+	msg := models.UnmarshallMesg(e.Mesg)
+	if e.Dir == models.ReplayIn {
+		return msg.Type == models.Spell || msg.Type == models.Chat
+	}
+	return !replaySkipped[msg.Type]
+}
+
+// Length is the duration of the recording
+func (r *Replay) Length() time.Duration {
+	if len(r.all) == 0 {
+		return 0
+	}
+	return time.Duration(r.all[len(r.all)-1].At) * time.Millisecond
+}
+
+// Position is where the playback is
+func (r *Replay) Position() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.position()
+}
+
+func (r *Replay) position() time.Duration {
+	if r.paused {
+		return r.base
+	}
+	return r.base + time.Duration(float64(time.Since(r.anchor))*r.speed)
+}
+
+func (r *Replay) Paused() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.paused
+}
+
+func (r *Replay) Speed() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.speed
+}
+
+func (r *Replay) TogglePause() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.base = r.position()
+	r.anchor = time.Now()
+	r.paused = !r.paused
+}
+
+// SetSpeed changes how fast the replay plays, 1 is real time
+func (r *Replay) SetSpeed(speed float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.base = r.position()
+	r.anchor = time.Now()
+	r.speed = speed
+}
+
+// Seek moves the playback to pos
+func (r *Replay) Seek(pos time.Duration) {
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > r.Length() {
+		pos = r.Length()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.seek(pos)
+}
+
+func (r *Replay) seek(pos time.Duration) {
+	r.base = pos
+	r.anchor = time.Now()
+	r.next = sort.Search(len(r.entries), func(i int) bool {
+		return time.Duration(r.entries[i].At)*time.Millisecond >= pos
+	})
+	r.seeked = true
+}
+
+// snapshots returns the latest state messages before the next entry
+func (r *Replay) snapshots() [][]byte {
+	latest := map[models.Event][]byte{}
+	for i := 0; i < r.next; i++ {
+		msg := models.UnmarshallMesg(r.entries[i].Mesg)
+		latest[msg.Type] = r.entries[i].Mesg
+	}
+	msgs := [][]byte{}
+	for _, t := range replaySnapshots {
+		if m, ok := latest[t]; ok {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+func (r *Replay) play(s *Socket) {
+	for s.Online {
+		r.mutex.Lock()
+		msgs := [][]byte{}
+		if r.seeked {
+			// the state before the new position is played at once
+			msgs = r.snapshots()
+			r.seeked = false
+		}
+		pos := r.position()
+		for r.next < len(r.entries) && time.Duration(r.entries[r.next].At)*time.Millisecond <= pos {
+			msgs = append(msgs, r.entries[r.next].Mesg)
+			r.next++
+		}
+		r.mutex.Unlock()
+
+		for _, m := range msgs {
+			s.I <- m
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
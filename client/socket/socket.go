@@ -3,11 +3,12 @@ package socket
 import (
 	"bufio"
 	"bytes"
-	"log"
+	"encoding/json"
+	"log/slog"
 	"net"
 	"os"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/juanefec/go-pixel-ao/models"
 	"github.com/segmentio/ksuid"
@@ -24,43 +25,65 @@ type Socket struct {
 // Close the connection and IO
 func (s *Socket) Close() {
 	s.Online = false
-	(*s.conn).Close()
+	// replays have no connection
+	if s.conn != nil {
+		(*s.conn).Close()
+	}
 }
 
-// NewSocket generation
-func NewSocket(ip string, port int) *Socket {
+const (
+	DialTimeout      = 5 * time.Second
+	HandshakeTimeout = 10 * time.Second
+)
 
-	addr := strings.Join([]string{ip, strconv.Itoa(port)}, ":")
-	conn, err := net.Dial("tcp", addr)
+// NewSocket generation, hs is sent to the server in the handshake.
+// It exits if the server can't be reached.
+func NewSocket(ip string, port int, hs models.HandshakeMsg) *Socket {
+	s, err := Dial(net.JoinHostPort(ip, strconv.Itoa(port)), hs)
 	if err != nil {
-		log.Fatalln(err)
+		slog.Error("Connecting", "err", err)
 		os.Exit(1)
 	}
+	return s
+}
+
+// Dial connects to addr, host:port, waits for the client ID and answers
+// with hs
+func Dial(addr string, hs models.HandshakeMsg) (*Socket, error) {
+	conn, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return nil, err
+	}
 	s := &Socket{
 		Online: true,
 		conn:   &conn,
 		I:      make(chan []byte),
 		O:      make(chan []byte, 512),
 	}
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
 	reader := bufio.NewReader(conn)
 	for s.ClientID == ksuid.Nil {
-		data, _, _ := reader.ReadLine()
+		data, _, err := reader.ReadLine()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
 		s.ClientID, err = ksuid.Parse(string(data))
 		if err != nil {
-			log.Println(string(data))
-			log.Println(err)
+			slog.Debug("Waiting for the client ID", "line", string(data), "err", err)
 		}
 		if s.ClientID != ksuid.Nil {
-			s.O <- models.NewMesg(models.ConfirmIDReception, nil)
-			log.Printf("Client ID: %v", s.ClientID.String())
+			payload, _ := json.Marshal(hs)
+			s.O <- models.NewMesg(models.ConfirmIDReception, payload)
+			slog.Info("Connected", "server", addr, "client", s.ClientID.String())
 		}
 
 	}
+	conn.SetReadDeadline(time.Time{})
 	go s.reciver()
 	go s.sender()
-	//log.Printf("listening io")
 
-	return s
+	return s, nil
 }
 
 //message order [updatePlayer|id;name;playerX;playerY;dir;moving]
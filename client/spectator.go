@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/segmentio/ksuid"
+)
+
+// Spectator is the camera of a player that is out of the game, it follows
+// a live player or flies free over the map
+type Spectator struct {
+	Watched ksuid.KSUID
+	FreeFly bool
+}
+
+var Spectate = Spectator{}
+
+// SetSpectator applies the role the server gave the player, spectators are
+// kept dead so they can't cast or be revived
+func (p *Player) SetSpectator(spectator bool) {
+	if spectator && !p.spectator {
+		p.dead = true
+		p.hp = 0
+	}
+	p.spectator = spectator
+}
+
+// Spectating reports if the player camera is a spectator one: spectators
+// and players waiting dead for the round to end
+func Spectating(p *Player) bool {
+	return p.spectator || (p.dead && Match.NoRespawn)
+}
+
+// Update handles the spectator keys: tab watches the next live player and
+// F switches between watching and flying free
+func (sp *Spectator) Update(win *pixelgl.Window, p *Player, pd *PlayersData) {
+	if p.chat.chatting {
+		return
+	}
+	if win.JustPressed(pixelgl.KeyF) {
+		sp.FreeFly = !sp.FreeFly
+	}
+	if win.JustPressed(pixelgl.KeyTab) || (!sp.FreeFly && sp.Target(pd) == nil) {
+		sp.Watched = sp.next(pd)
+		sp.FreeFly = false
+	}
+}
+
+// next returns the live player after the watched one
+func (sp *Spectator) next(pd *PlayersData) ksuid.KSUID {
+	live := []ksuid.KSUID{}
+	pd.AnimationsMutex.RLock()
+	for id, o := range pd.CurrentAnimations {
+		if !o.dead && !o.spectator {
+			live = append(live, id)
+		}
+	}
+	pd.AnimationsMutex.RUnlock()
+	if len(live) == 0 {
+		return ksuid.Nil
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return ksuid.Compare(live[i], live[j]) < 0
+	})
+	for i, id := range live {
+		if id == sp.Watched {
+			return live[(i+1)%len(live)]
+		}
+	}
+	return live[0]
+}
+
+// Target is the player being watched, nil when flying free or if it died
+func (sp *Spectator) Target(pd *PlayersData) *Player {
+	if sp.FreeFly {
+		return nil
+	}
+	pd.AnimationsMutex.RLock()
+	defer pd.AnimationsMutex.RUnlock()
+	o, ok := pd.CurrentAnimations[sp.Watched]
+	if !ok || o.dead || o.spectator {
+		return nil
+	}
+	return o
+}
+
+// Focus is where the camera is centered, free flying moves the own
+// invisible phantom around the map
+func (sp *Spectator) Focus(p *Player, pd *PlayersData) pixel.Vec {
+	if t := sp.Target(pd); t != nil {
+		return t.pos
+	}
+	return p.pos
+}
+
+// className is the catalog name of a wizard class
+func className(t WizardType) string {
+	switch t {
+	case Monk:
+		return tr("class.monk")
+	case Hunter:
+		return tr("class.hunter")
+	case Sniper:
+		return tr("class.sniper")
+	case DarkWizard:
+		return tr("class.pyro")
+	case Shaman:
+		return tr("class.shaman")
+	case Timewreker:
+		return tr("class.jumper")
+	}
+	return ""
+}
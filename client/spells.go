@@ -76,6 +76,36 @@ func (gs GameSpells) Draw(win *pixelgl.Window, cam pixel.Matrix, s *socket.Socke
 
 }
 
+// supportSpells help whoever they land on
+var supportSpells = map[string]bool{
+	"healshot":  true,
+	"heal-spot": true,
+	"mana-spot": true,
+}
+
+// SpellAffects reports if a spell cast by caster has to be resolved on target.
+// Support spells only land on allies, the rest only on enemies, or on team
+// mates too when the server enables friendly fire.
+func SpellAffects(spellName string, caster, target *Player) bool {
+	if target.spectator || (caster != nil && caster.spectator) {
+		return false
+	}
+	if caster == nil {
+		return !supportSpells[spellName]
+	}
+	// duelists only fight each other, once the countdown is over
+	if caster.duel != target.duel || (target.duel != ksuid.Nil && !target.duelFighting) {
+		return false
+	}
+	if supportSpells[spellName] {
+		return caster.IsAlly(target)
+	}
+	if caster == target {
+		return false
+	}
+	return FriendlyFire || !caster.IsAlly(target)
+}
+
 type SpellData struct {
 	EffectRadius      float64
 	SpellLifespawn    float64
@@ -112,7 +142,7 @@ func (sd *SpellData) UpdateOnTarget(win *pixelgl.Window, cam pixel.Matrix, s *so
 				if Dist(mouse, cam.Unproject(win.Bounds().Center())) <= OnTargetSpellRange {
 					for key := range pd.CurrentAnimations {
 
-						if !pd.CurrentAnimations[key].dead && cursor.Mode != SpellCastPrimarySkill && pd.CurrentAnimations[key].OnMe(mouse) {
+						if !pd.CurrentAnimations[key].dead && cursor.Mode != SpellCastPrimarySkill && pd.CurrentAnimations[key].OnMe(mouse) && SpellAffects(sd.SpellName, sd.Caster, pd.CurrentAnimations[key]) {
 							spell := models.SpellMsg{
 								ID:        s.ClientID,
 								SpellType: sd.SpellType,
@@ -244,7 +274,7 @@ FBALLS:
 		}
 		for key := range pd.CurrentAnimations {
 			p := pd.CurrentAnimations[key]
-			if sd.CurrentAnimations[i].caster != key && !p.dead && p.OnMe(sd.CurrentAnimations[i].pos) {
+			if sd.CurrentAnimations[i].caster != key && !p.dead && p.OnMe(sd.CurrentAnimations[i].pos) && SpellAffects(sd.SpellName, pd.Get(sd.CurrentAnimations[i].caster, s.ClientID, sd.Caster), p) {
 				if i < len(sd.CurrentAnimations)-1 {
 					copy(sd.CurrentAnimations[i:], sd.CurrentAnimations[i+1:])
 				}
@@ -276,7 +306,7 @@ FBALLS:
 				continue FBALLS
 			}
 		}
-		if sd.CurrentAnimations[i].caster != s.ClientID && !sd.Caster.dead && sd.Caster.OnMe(sd.CurrentAnimations[i].pos) {
+		if sd.CurrentAnimations[i].caster != s.ClientID && !sd.Caster.dead && sd.Caster.OnMe(sd.CurrentAnimations[i].pos) && SpellAffects(sd.SpellName, pd.Get(sd.CurrentAnimations[i].caster, s.ClientID, sd.Caster), sd.Caster) {
 			casterID := sd.CurrentAnimations[i].caster
 			effect := &Spell{
 				target:      sd.Caster,
@@ -443,7 +473,7 @@ FBALLS:
 		}
 		for key := range pd.CurrentAnimations {
 			p := pd.CurrentAnimations[key]
-			if sd.CurrentAnimations[i].caster != key && !p.dead && p.OnMe(sd.CurrentAnimations[i].pos) {
+			if sd.CurrentAnimations[i].caster != key && !p.dead && p.OnMe(sd.CurrentAnimations[i].pos) && SpellAffects(sd.SpellName, pd.Get(sd.CurrentAnimations[i].caster, s.ClientID, sd.Caster), p) {
 				effect := &Spell{
 					target:      p,
 					caster:      s.ClientID,
@@ -478,7 +508,7 @@ FBALLS:
 				continue FBALLS
 			}
 		}
-		if sd.CurrentAnimations[i].caster != s.ClientID && !sd.Caster.dead && sd.Caster.OnMe(sd.CurrentAnimations[i].pos) {
+		if sd.CurrentAnimations[i].caster != s.ClientID && !sd.Caster.dead && sd.Caster.OnMe(sd.CurrentAnimations[i].pos) && SpellAffects(sd.SpellName, pd.Get(sd.CurrentAnimations[i].caster, s.ClientID, sd.Caster), sd.Caster) {
 			effect := &Spell{
 				target:      sd.Caster,
 				caster:      s.ClientID,
@@ -606,6 +636,9 @@ func (sd *SpellData) UpdateAOE(win *pixelgl.Window, cam pixel.Matrix, s *socket.
 			case "lava-spot", "heal-spot", "mana-spot":
 				dt := time.Since(sd.CurrentAnimations[i].damageInterval).Seconds()
 				sd.CurrentAnimations[i].damageInterval = time.Now()
+				if !SpellAffects(sd.SpellName, pd.Get(sd.CurrentAnimations[i].caster, s.ClientID, sd.Caster), sd.Caster) {
+					break
+				}
 				if sd.WizardCaster == Shaman {
 					sd.Caster.mp -= float64(sd.Damage) * dt
 					if sd.Caster.mp > sd.Caster.maxmp {
@@ -732,7 +765,7 @@ func (sd *SpellData) UpdateTrap(win *pixelgl.Window, cam pixel.Matrix, s *socket
 			continue
 		}
 
-		if !sd.Caster.dead && sd.Caster.OnTrap(sd.CurrentAnimations[i].pos) {
+		if !sd.Caster.dead && sd.Caster.OnTrap(sd.CurrentAnimations[i].pos) && SpellAffects(sd.SpellName, pd.Get(sd.CurrentAnimations[i].caster, s.ClientID, sd.Caster), sd.Caster) {
 			if sd.SpellName == "hunter-trap" {
 				if !sd.CurrentAnimations[i].trapped {
 					sd.Caster.lastRootedStart = time.Now().Add(time.Second)
@@ -744,7 +777,7 @@ func (sd *SpellData) UpdateTrap(win *pixelgl.Window, cam pixel.Matrix, s *socket
 		}
 		for key := range pd.CurrentAnimations {
 			p := pd.CurrentAnimations[key]
-			if !p.dead && p.OnTrap(sd.CurrentAnimations[i].pos) {
+			if !p.dead && p.OnTrap(sd.CurrentAnimations[i].pos) && SpellAffects(sd.SpellName, pd.Get(sd.CurrentAnimations[i].caster, s.ClientID, sd.Caster), p) {
 				if sd.SpellName == "hunter-trap" {
 					if !sd.CurrentAnimations[i].trapped {
 						sd.CurrentAnimations[i].trapped = true
@@ -2,12 +2,12 @@ package main
 
 import (
 	"image"
-	"log"
 	"math"
 	"os"
 	"sync"
 
 	"github.com/faiface/pixel"
+	"github.com/juanefec/go-pixel-ao/logging"
 )
 
 func getFrames(pic pixel.Picture, w, h, qw, qh float64) (frames []pixel.Rect) {
@@ -88,7 +88,7 @@ func loadPictures(files ...string) map[string]pixel.Picture {
 			content, err := loadPicture(file)
 
 			if err != nil {
-				log.Fatal(err)
+				logging.Fatal("Loading picture", "file", file, "err", err)
 			}
 
 			m.Lock()
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/juanefec/go-pixel-ao/models"
+	"golang.org/x/image/colornames"
+)
+
+// Zone is the battle royale safe zone last sent by the server
+var Zone = models.ZoneMsg{}
+
+// DrawZone draws the safe zone ring and, thinner, the one it shrinks to
+func DrawZone(win *pixelgl.Window) {
+	if Match.Mode != "br" || Match.State != models.MatchRunning {
+		return
+	}
+	imd := imdraw.New(nil)
+	imd.Color = colornames.White
+	if Zone.NextRadius > 0 {
+		imd.Push(pixel.V(Zone.NextX, Zone.NextY))
+		imd.Circle(Zone.NextRadius, 2)
+	}
+	imd.Color = colornames.Cornflowerblue
+	if Zone.Radius > 0 {
+		imd.Push(pixel.V(Zone.X, Zone.Y))
+		imd.Circle(Zone.Radius, 8)
+	}
+	imd.Draw(win)
+}
+
+// zoneStatus is the countdown to the next shrink
+func zoneStatus() string {
+	if Zone.NextShrink > 0 {
+		return tr("zone.shrinks_in", int(math.Ceil(Zone.NextShrink)))
+	}
+	if Zone.NextRadius < Zone.Radius {
+		return tr("zone.shrinking")
+	}
+	return ""
+}
@@ -0,0 +1,280 @@
+// Command loadtest connects many scripted clients to a server to see how
+// much a room can take. Every client does the handshake, joins a room,
+// walks in a pattern and casts spells, then the traffic, the snapshot
+// latency and the disconnections are reported.
+//
+//	go run ./cmd/loadtest -n 100 -duration 1m -pattern circle
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/juanefec/go-pixel-ao/sdk"
+)
+
+var spells = []string{"fireball", "icesnipe", "rockshot", "manashot", "healshot"}
+
+type config struct {
+	addr      string
+	room      string
+	clients   int
+	duration  time.Duration
+	ramp      time.Duration
+	pattern   string
+	moveRate  float64
+	castRate  float64
+	chatRate  float64
+	reportInt time.Duration
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.addr, "addr", "localhost:33333", "server address")
+	flag.StringVar(&cfg.room, "room", "main", "room the clients join")
+	flag.IntVar(&cfg.clients, "n", 50, "number of clients")
+	flag.DurationVar(&cfg.duration, "duration", time.Minute, "how long the test runs once every client is connected")
+	flag.DurationVar(&cfg.ramp, "ramp", 5*time.Second, "time to spread the connections over")
+	flag.StringVar(&cfg.pattern, "pattern", "circle", "how clients move: circle, line, random or idle")
+	flag.Float64Var(&cfg.moveRate, "move-rate", 20, "state updates per second per client")
+	flag.Float64Var(&cfg.castRate, "cast-rate", 0.5, "spells per second per client")
+	flag.Float64Var(&cfg.chatRate, "chat-rate", 0, "chat messages per second per client")
+	flag.DurationVar(&cfg.reportInt, "report", 5*time.Second, "interval between progress reports")
+	flag.Parse()
+
+	if _, ok := patterns[cfg.pattern]; !ok {
+		log.Fatalf("unknown pattern %q", cfg.pattern)
+	}
+	if cfg.moveRate <= 0 {
+		log.Fatal("-move-rate must be positive, the server only answers state updates")
+	}
+
+	r := NewRecorder()
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	for i := 0; i < cfg.clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runClient(i, cfg, r, stop)
+		}(i)
+		if cfg.clients > 1 {
+			time.Sleep(cfg.ramp / time.Duration(cfg.clients))
+		}
+	}
+
+	start := time.Now()
+	report := time.NewTicker(cfg.reportInt)
+	end := time.After(cfg.duration)
+	for running := true; running; {
+		select {
+		case <-report.C:
+			r.Report(time.Since(start), false)
+		case <-end:
+			running = false
+		}
+	}
+	report.Stop()
+	close(stop)
+	wg.Wait()
+	fmt.Println("--- final ---")
+	r.Report(time.Since(start), true)
+}
+
+// runClient plays one client until stop is closed or it gets disconnected
+func runClient(i int, cfg config, r *Recorder, stop chan struct{}) {
+	c, err := sdk.Dial(cfg.addr, sdk.Options{
+		Name:       fmt.Sprintf("load%d", i),
+		Class:      i % 6,
+		Skin:       i % 6,
+		UpdateRate: -1,
+	})
+	if err != nil {
+		r.Failed(err)
+		return
+	}
+	defer c.Close()
+
+	// the server answers every state update with a snapshot, in order, the
+	// one for the update Join sends isn't timed
+	sent := []time.Time{}
+	mutex := &sync.Mutex{}
+	skipped := false
+	c.OnPlayers(func(p []models.PlayerMsg) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !skipped {
+			skipped = true
+			return
+		}
+		if len(sent) > 0 {
+			r.Latency(time.Since(sent[0]))
+			sent = sent[1:]
+		}
+	})
+	if err := c.Join(cfg.room); err != nil {
+		r.Failed(err)
+		return
+	}
+	r.Connected()
+
+	move := time.NewTicker(time.Duration(float64(time.Second) / cfg.moveRate))
+	defer move.Stop()
+	cast := ticker(cfg.castRate)
+	chat := ticker(cfg.chatRate)
+	walk := patterns[cfg.pattern]
+	origin := time.Now()
+	cx, cy := 1500+rand.Float64()*1000, 2400+rand.Float64()*1000
+	for {
+		select {
+		case <-move.C:
+			x, y := walk(time.Since(origin).Seconds()+float64(i), cx, cy)
+			s := c.State()
+			dir := direction(x-s.X, y-s.Y)
+			mutex.Lock()
+			sent = append(sent, time.Now())
+			mutex.Unlock()
+			if err := c.Move(x, y, dir, x != s.X || y != s.Y); err != nil {
+				r.Disconnected(err, c.Stats())
+				return
+			}
+		case <-cast:
+			s := c.State()
+			a := rand.Float64() * 2 * math.Pi
+			c.Cast(spells[rand.Intn(len(spells))], s.X+math.Cos(a)*300, s.Y+math.Sin(a)*300)
+		case <-chat:
+			c.Say(fmt.Sprintf("load test %d", rand.Intn(1000)))
+		case <-c.Done():
+			r.Disconnected(c.Err(), c.Stats())
+			return
+		case <-stop:
+			r.Traffic(c.Stats())
+			return
+		}
+	}
+}
+
+// ticker fires rate times a second, never if the rate is zero
+func ticker(rate float64) <-chan time.Time {
+	if rate <= 0 {
+		return nil
+	}
+	return time.NewTicker(time.Duration(float64(time.Second) / rate)).C
+}
+
+func direction(dx, dy float64) string {
+	switch {
+	case math.Abs(dx) > math.Abs(dy) && dx > 0:
+		return "right"
+	case math.Abs(dx) > math.Abs(dy):
+		return "left"
+	case dy > 0:
+		return "up"
+	}
+	return "down"
+}
+
+// patterns give the position at t seconds for a client walking around cx, cy
+var patterns = map[string]func(t, cx, cy float64) (float64, float64){
+	"circle": func(t, cx, cy float64) (float64, float64) {
+		return cx + math.Cos(t)*200, cy + math.Sin(t)*200
+	},
+	"line": func(t, cx, cy float64) (float64, float64) {
+		return cx + math.Sin(t/2)*400, cy
+	},
+	"random": func(t, cx, cy float64) (float64, float64) {
+		return cx + rand.Float64()*40 - 20 + math.Sin(t/3)*300, cy + rand.Float64()*40 - 20 + math.Cos(t/5)*300
+	},
+	"idle": func(t, cx, cy float64) (float64, float64) {
+		return cx, cy
+	},
+}
+
+// Recorder collects what every client saw
+type Recorder struct {
+	connected, failed, disconnected int64
+	mutex                           *sync.Mutex
+	latencies                       []time.Duration
+	traffic                         sdk.Stats
+	errors                          map[string]int
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{mutex: &sync.Mutex{}, errors: map[string]int{}}
+}
+
+func (r *Recorder) Connected() { atomic.AddInt64(&r.connected, 1) }
+
+func (r *Recorder) Failed(err error) {
+	atomic.AddInt64(&r.failed, 1)
+	r.error(err)
+}
+
+func (r *Recorder) Disconnected(err error, s sdk.Stats) {
+	atomic.AddInt64(&r.disconnected, 1)
+	r.error(err)
+	r.Traffic(s)
+}
+
+func (r *Recorder) error(err error) {
+	r.mutex.Lock()
+	r.errors[fmt.Sprint(err)]++
+	r.mutex.Unlock()
+}
+
+func (r *Recorder) Latency(d time.Duration) {
+	r.mutex.Lock()
+	r.latencies = append(r.latencies, d)
+	r.mutex.Unlock()
+}
+
+// Traffic adds the counters of a client that finished
+func (r *Recorder) Traffic(s sdk.Stats) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.traffic.Sent += s.Sent
+	r.traffic.Received += s.Received
+	r.traffic.BytesSent += s.BytesSent
+	r.traffic.BytesReceived += s.BytesReceived
+}
+
+// Report prints the numbers so far, the traffic is only known at the end
+// when clients stop
+func (r *Recorder) Report(elapsed time.Duration, final bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	fmt.Printf("%6.0fs  connected %d  failed %d  disconnected %d\n", elapsed.Seconds(),
+		atomic.LoadInt64(&r.connected), atomic.LoadInt64(&r.failed), atomic.LoadInt64(&r.disconnected))
+	l := r.latencies
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	if len(l) > 0 {
+		fmt.Printf("        snapshots %d (%.0f/s)  latency p50 %v  p90 %v  p99 %v  max %v\n", len(l), float64(len(l))/elapsed.Seconds(),
+			percentile(l, .5), percentile(l, .9), percentile(l, .99), l[len(l)-1])
+	}
+	if !final {
+		return
+	}
+	t, secs := r.traffic, elapsed.Seconds()
+	fmt.Printf("        sent %d msgs (%.0f/s, %.1f KB/s)  received %d msgs (%.0f/s, %.1f KB/s)\n",
+		t.Sent, float64(t.Sent)/secs, float64(t.BytesSent)/secs/1024,
+		t.Received, float64(t.Received)/secs, float64(t.BytesReceived)/secs/1024)
+	for err, n := range r.errors {
+		fmt.Printf("        %d x %v\n", n, err)
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
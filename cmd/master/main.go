@@ -0,0 +1,142 @@
+// Command master is the registry game servers announce themselves to, so
+// players find them beyond the LAN. Servers send a signed heartbeat every
+// 30 seconds and are dropped when they stop, clients list the servers from
+// the browser of the login.
+//
+//	go run ./cmd/master -listen :33380 -secret s3cret
+//	go run ./server -master http://localhost:33380 -master-secret s3cret
+//	go run ./client -master http://localhost:33380
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/registry"
+)
+
+// MinTTL is the shortest -ttl taken, servers are expired every third of it
+const MinTTL = 3 * time.Second
+
+func main() {
+	listen := flag.String("listen", ":33380", "address of the registry")
+	secret := flag.String("secret", os.Getenv("GOAO_MASTER_SECRET"), "secret shared with the game servers")
+	ttl := flag.Duration("ttl", 3*registry.HeartbeatInterval, "how long a server stays listed without heartbeats")
+	flag.Parse()
+	if *secret == "" {
+		log.Fatal("the registry needs a -secret or GOAO_MASTER_SECRET")
+	}
+	if *ttl < MinTTL {
+		log.Fatalf("-ttl has to be at least %v", MinTTL)
+	}
+
+	r := NewRegistry(*ttl)
+	go func() {
+		for range time.Tick(*ttl / 3) {
+			if n := r.Expire(); n > 0 {
+				log.Printf("Expired %d servers", n)
+			}
+		}
+	}()
+	log.Printf("Registry on http://%v/servers", *listen)
+	log.Fatal(http.ListenAndServe(*listen, r.Handler(*secret)))
+}
+
+// Registry holds the servers alive by address
+type Registry struct {
+	ttl     time.Duration
+	servers map[string]registry.Entry
+	mutex   *sync.Mutex
+}
+
+func NewRegistry(ttl time.Duration) *Registry {
+	return &Registry{ttl: ttl, servers: map[string]registry.Entry{}, mutex: &sync.Mutex{}}
+}
+
+func (r *Registry) Add(e registry.Entry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.servers[e.Addr]; !ok {
+		log.Printf("Listed %q at %v", e.Name, e.Addr)
+	}
+	r.servers[e.Addr] = e
+}
+
+// Expire drops the servers without heartbeats for ttl
+func (r *Registry) Expire() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for addr, e := range r.servers {
+		if time.Since(e.LastSeen) > r.ttl {
+			delete(r.servers, addr)
+			n++
+		}
+	}
+	return n
+}
+
+// List returns the servers alive, the fullest first
+func (r *Registry) List() []registry.Entry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	list := make([]registry.Entry, 0, len(r.servers))
+	for _, e := range r.servers {
+		if time.Since(e.LastSeen) <= r.ttl {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Players != list[j].Players {
+			return list[i].Players > list[j].Players
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func (r *Registry) Handler(secret string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heartbeat", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, registry.MaxBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		hb, err := registry.Verify(secret, req.Header.Get(registry.SignatureHeader), body)
+		if err != nil {
+			log.Printf("Refused heartbeat from %v: %v", req.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		addr := hb.Addr
+		if addr == "" {
+			host, _, err := net.SplitHostPort(req.RemoteAddr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			addr = net.JoinHostPort(host, strconv.Itoa(hb.Port))
+		}
+		r.Add(registry.Entry{ServerInfoMsg: hb.ServerInfoMsg, Addr: addr, LastSeen: time.Now()})
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.List())
+	})
+	return mux
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/juanefec/go-pixel-ao/registry"
+)
+
+func TestExpire(t *testing.T) {
+	ttl := time.Minute
+	tests := []struct {
+		name    string
+		seen    []time.Duration // ago, by server
+		listed  int
+		expired int
+	}{
+		{"none", nil, 0, 0},
+		{"alive", []time.Duration{0, ttl / 2}, 2, 0},
+		{"gone", []time.Duration{2 * ttl}, 0, 1},
+		{"some gone", []time.Duration{0, ttl + time.Second, 2 * ttl}, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(ttl)
+			for i, ago := range tt.seen {
+				r.Add(registry.Entry{Addr: string(rune('a' + i)), LastSeen: time.Now().Add(-ago)})
+			}
+			// listing leaves out the expired ones before Expire drops them
+			if n := len(r.List()); n != tt.listed {
+				t.Errorf("listed %d, want %d", n, tt.listed)
+			}
+			if n := r.Expire(); n != tt.expired {
+				t.Errorf("expired %d, want %d", n, tt.expired)
+			}
+			if n := len(r.servers); n != tt.listed {
+				t.Errorf("%d servers kept, want %d", n, tt.listed)
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	body := func(at time.Time) []byte {
+		hb := registry.Heartbeat{ServerInfoMsg: models.ServerInfoMsg{Name: "main", Port: 33333}, Addr: "example.com:33333", Time: at.Unix()}
+		data, _ := json.Marshal(hb)
+		return data
+	}
+	now := time.Now()
+	tests := []struct {
+		name      string
+		method    string
+		body      []byte
+		signature func(body []byte) string
+		status    int
+	}{
+		{"signed", http.MethodPost, body(now), func(b []byte) string { return registry.Sign("secret", b) }, http.StatusNoContent},
+		{"unsigned", http.MethodPost, body(now), func(b []byte) string { return "" }, http.StatusUnauthorized},
+		{"other secret", http.MethodPost, body(now), func(b []byte) string { return registry.Sign("other", b) }, http.StatusUnauthorized},
+		{"stale", http.MethodPost, body(now.Add(-2 * registry.MaxClockSkew)), func(b []byte) string { return registry.Sign("secret", b) }, http.StatusUnauthorized},
+		{"too large", http.MethodPost, make([]byte, registry.MaxBody+1), func(b []byte) string { return registry.Sign("secret", b) }, http.StatusRequestEntityTooLarge},
+		{"get", http.MethodGet, nil, func(b []byte) string { return "" }, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(time.Minute)
+			req := httptest.NewRequest(tt.method, "/heartbeat", bytes.NewReader(tt.body))
+			req.Header.Set(registry.SignatureHeader, tt.signature(tt.body))
+			w := httptest.NewRecorder()
+			r.Handler("secret").ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			listed := len(r.List())
+			if ok := tt.status == http.StatusNoContent; ok != (listed == 1) {
+				t.Errorf("%d servers listed after status %d", listed, w.Code)
+			}
+		})
+	}
+}
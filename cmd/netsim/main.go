@@ -0,0 +1,138 @@
+// Command netsim is a TCP proxy that puts a bad network between the game
+// client and the server: latency, jitter, bandwidth caps and random
+// disconnections. The profile can be changed while it runs by typing in
+// its terminal or through its control address.
+//
+//	go run ./cmd/netsim -upstream localhost:33333 -listen :33334 -profile wifi
+//	go run ./client -server localhost:33334
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	listen := flag.String("listen", ":33334", "address clients connect to")
+	upstream := flag.String("upstream", "localhost:33333", "game server address")
+	profileName := flag.String("profile", "lan", "starting profile: "+strings.Join(profileNames(), ", "))
+	control := flag.String("control", "", "address of the http control, empty to only use the terminal")
+	flag.Parse()
+
+	p, ok := profiles[*profileName]
+	if !ok {
+		log.Fatalf("unknown profile %q", *profileName)
+	}
+	px := NewProxy(*upstream, p)
+	log.Println(status(px))
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Listening on %v, forwarding to %v", *listen, *upstream)
+	if *control != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*control, controlHandler(px)))
+		}()
+		log.Printf("Control on http://%v", *control)
+	}
+	go commands(px)
+	log.Fatal(px.Serve(listener))
+}
+
+func profileNames() []string {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+const help = `commands:
+  profile NAME       switch to a profile (%v)
+  latency DURATION   one way latency, like 80ms
+  jitter DURATION    latency variation
+  bandwidth BYTES    bytes per second each way, 0 for unlimited
+  disconnects N      random disconnections a minute per connection
+  disconnect         cut every connection now
+  status             show the current profile
+`
+
+// commands reads control commands from the terminal
+func commands(px *Proxy) {
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			fmt.Println(run(px, strings.Fields(line)))
+		}
+	}
+}
+
+// run applies a command and returns what to answer
+func run(px *Proxy, args []string) string {
+	p := px.Profile()
+	arg := ""
+	if len(args) > 1 {
+		arg = args[1]
+	}
+	var err error
+	switch args[0] {
+	case "profile":
+		np, ok := profiles[arg]
+		if !ok {
+			return fmt.Sprintf("unknown profile %q, try %v", arg, strings.Join(profileNames(), ", "))
+		}
+		p = np
+	case "latency":
+		p.Latency, err = time.ParseDuration(arg)
+	case "jitter":
+		p.Jitter, err = time.ParseDuration(arg)
+	case "bandwidth":
+		p.Bandwidth, err = strconv.Atoi(arg)
+	case "disconnects":
+		p.Disconnects, err = strconv.ParseFloat(arg, 64)
+	case "disconnect":
+		return fmt.Sprintf("cut %d connections", px.DisconnectAll())
+	case "status":
+		return status(px)
+	default:
+		return fmt.Sprintf(help, strings.Join(profileNames(), ", "))
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if args[0] != "profile" {
+		p.Name = "custom"
+	}
+	px.SetProfile(p)
+	return status(px)
+}
+
+func status(px *Proxy) string {
+	p := px.Profile()
+	return fmt.Sprintf("%v: latency %v jitter %v bandwidth %v B/s disconnects %v/min, %d connections",
+		p.Name, p.Latency, p.Jitter, p.Bandwidth, p.Disconnects, px.Connections())
+}
+
+// controlHandler takes the same commands over http, like
+// /profile?arg=mobile or /disconnect
+func controlHandler(px *Proxy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := []string{strings.Trim(r.URL.Path, "/")}
+		if arg := r.URL.Query().Get("arg"); arg != "" {
+			args = append(args, arg)
+		}
+		fmt.Fprintln(w, run(px, args))
+	})
+}
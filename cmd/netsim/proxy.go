@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Profile is a set of network conditions, applied on each direction
+type Profile struct {
+	Name    string
+	Latency time.Duration // one way
+	Jitter  time.Duration // latency varies up to this much either way
+	// Bandwidth caps each direction in bytes per second, 0 is unlimited
+	Bandwidth int
+	// Disconnects is how many times a minute a connection is cut, on
+	// average
+	Disconnects float64
+}
+
+var profiles = map[string]Profile{
+	"lan":    {Name: "lan"},
+	"dsl":    {Name: "dsl", Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond, Bandwidth: 1 << 20},
+	"wifi":   {Name: "wifi", Latency: 40 * time.Millisecond, Jitter: 30 * time.Millisecond, Bandwidth: 512 << 10, Disconnects: .05},
+	"mobile": {Name: "mobile", Latency: 80 * time.Millisecond, Jitter: 60 * time.Millisecond, Bandwidth: 128 << 10, Disconnects: .2},
+	"awful":  {Name: "awful", Latency: 200 * time.Millisecond, Jitter: 150 * time.Millisecond, Bandwidth: 24 << 10, Disconnects: 1},
+}
+
+// Proxy forwards connections to the upstream server under the current
+// profile, which can change while connections are open
+type Proxy struct {
+	Upstream string
+	profile  atomic.Value // Profile
+	mutex    *sync.Mutex
+	conns    map[*link]bool
+}
+
+func NewProxy(upstream string, p Profile) *Proxy {
+	px := &Proxy{Upstream: upstream, mutex: &sync.Mutex{}, conns: map[*link]bool{}}
+	px.profile.Store(p)
+	return px
+}
+
+func (px *Proxy) Profile() Profile {
+	return px.profile.Load().(Profile)
+}
+
+func (px *Proxy) SetProfile(p Profile) {
+	px.profile.Store(p)
+	log.Printf("Profile %v: latency %v jitter %v bandwidth %v B/s disconnects %v/min", p.Name, p.Latency, p.Jitter, p.Bandwidth, p.Disconnects)
+}
+
+// DisconnectAll cuts every open connection
+func (px *Proxy) DisconnectAll() int {
+	px.mutex.Lock()
+	defer px.mutex.Unlock()
+	for l := range px.conns {
+		l.close()
+	}
+	return len(px.conns)
+}
+
+func (px *Proxy) Connections() int {
+	px.mutex.Lock()
+	defer px.mutex.Unlock()
+	return len(px.conns)
+}
+
+func (px *Proxy) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go px.handle(conn)
+	}
+}
+
+// link is a client connection and its upstream one
+type link struct {
+	client, server net.Conn
+	once           sync.Once
+	done           chan struct{}
+}
+
+func (l *link) close() {
+	l.once.Do(func() {
+		close(l.done)
+		l.client.Close()
+		l.server.Close()
+	})
+}
+
+func (px *Proxy) handle(client net.Conn) {
+	server, err := net.Dial("tcp", px.Upstream)
+	if err != nil {
+		log.Printf("Error dialing %v: %v", px.Upstream, err)
+		client.Close()
+		return
+	}
+	l := &link{client: client, server: server, done: make(chan struct{})}
+	px.mutex.Lock()
+	px.conns[l] = true
+	px.mutex.Unlock()
+	log.Printf("Proxying %v", client.RemoteAddr())
+
+	go px.pipe(l, client, server)
+	go px.pipe(l, server, client)
+	px.chaos(l)
+
+	px.mutex.Lock()
+	delete(px.conns, l)
+	px.mutex.Unlock()
+	log.Printf("Closed %v", client.RemoteAddr())
+}
+
+// chaos cuts the link at random as often as the profile says, it returns
+// once the link is closed
+func (px *Proxy) chaos(l *link) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if rand.Float64() < px.Profile().Disconnects/60 {
+				log.Printf("Disconnecting %v", l.client.RemoteAddr())
+				l.close()
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+type chunk struct {
+	data []byte
+	at   time.Time // when it is delivered
+}
+
+// pipe copies from src to dst delaying every read by the profile latency,
+// in order like TCP does, and no faster than its bandwidth
+func (px *Proxy) pipe(l *link, src, dst net.Conn) {
+	defer l.close()
+	queue := make(chan chunk, 4096)
+	go func() {
+		defer close(queue)
+		var last time.Time
+		for {
+			buf := make([]byte, 16<<10)
+			n, err := src.Read(buf)
+			if n > 0 {
+				p := px.Profile()
+				delay := p.Latency
+				if p.Jitter > 0 {
+					delay += time.Duration(rand.Int63n(int64(2*p.Jitter))) - p.Jitter
+				}
+				at := time.Now().Add(delay)
+				// jitter never reorders the stream
+				if at.Before(last) {
+					at = last
+				}
+				last = at
+				select {
+				case queue <- chunk{data: buf[:n], at: at}:
+				case <-l.done:
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					l.close()
+				}
+				return
+			}
+		}
+	}()
+
+	for c := range queue {
+		if wait := time.Until(c.at); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-l.done:
+				return
+			}
+		}
+		if err := px.write(l, dst, c.data); err != nil {
+			return
+		}
+	}
+}
+
+// write sends data in slices small enough to keep the bandwidth cap
+func (px *Proxy) write(l *link, dst net.Conn, data []byte) error {
+	for len(data) > 0 {
+		bw := px.Profile().Bandwidth
+		n := len(data)
+		if bw > 0 && n > bw/20 {
+			n = bw/20 + 1
+		}
+		start := time.Now()
+		if _, err := dst.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		if bw > 0 {
+			spent := time.Duration(float64(n) / float64(bw) * float64(time.Second))
+			select {
+			case <-time.After(spent - time.Since(start)):
+			case <-l.done:
+				return io.ErrClosedPipe
+			}
+		}
+	}
+	return nil
+}
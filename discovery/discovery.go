@@ -0,0 +1,125 @@
+// Package discovery finds game servers on the local network. Clients send a
+// probe over UDP, by broadcast or to a known address, and servers answer
+// with a models.ServerInfoMsg on the same port number they play on.
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+const (
+	// DefaultPort is where clients broadcast probes, the default game port
+	DefaultPort = 33333
+	// Magic tells probes apart from anything else reaching the port
+	Magic = "goao-probe"
+	// MaxPacket is the largest probe or answer read
+	MaxPacket = 1024
+	// ProbeSize pads probes so answers are never larger than the probe
+	// that asked for them, the server can't be used to amplify traffic
+	ProbeSize = 512
+)
+
+// Probe asks servers for their info
+type Probe struct {
+	Magic   string `json:"magic"`
+	Sent    int64  `json:"sent"` // unix nanoseconds, echoed in the answer
+	Padding string `json:"padding,omitempty"`
+}
+
+// Serve answers the probes reaching conn with what info returns, until conn
+// is closed
+func Serve(conn net.PacketConn, info func() models.ServerInfoMsg) error {
+	buf := make([]byte, MaxPacket)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		p := Probe{}
+		if n < ProbeSize || json.Unmarshal(buf[:n], &p) != nil || p.Magic != Magic {
+			continue
+		}
+		answer := info()
+		answer.Sent = p.Sent
+		data, _ := json.Marshal(answer)
+		if len(data) > n {
+			continue
+		}
+		conn.WriteTo(data, addr)
+	}
+}
+
+// Server is a server that answered a probe
+type Server struct {
+	models.ServerInfoMsg
+	Addr string        // host:port to play in
+	Ping time.Duration // round trip of the probe
+}
+
+// Query broadcasts a probe on ports and sends one to each of addrs, then
+// collects the answers for wait. Servers answering more than once are
+// listed once, sorted by ping.
+func Query(ports []int, addrs []string, wait time.Duration) ([]Server, error) {
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	probe := newProbe()
+	for _, port := range ports {
+		conn.WriteTo(probe, &net.UDPAddr{IP: net.IPv4bcast, Port: port})
+	}
+	for _, addr := range addrs {
+		if udp, err := net.ResolveUDPAddr("udp", addr); err == nil {
+			conn.WriteTo(probe, udp)
+		}
+	}
+
+	found := map[string]Server{}
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, MaxPacket)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		s := Server{}
+		if json.Unmarshal(buf[:n], &s.ServerInfoMsg) != nil || s.Sent == 0 {
+			continue
+		}
+		host, _, err := net.SplitHostPort(from.String())
+		if err != nil {
+			continue
+		}
+		s.Addr = net.JoinHostPort(host, strconv.Itoa(s.Port))
+		s.Ping = time.Since(time.Unix(0, s.Sent))
+		if prev, ok := found[s.Addr]; !ok || s.Ping < prev.Ping {
+			found[s.Addr] = s
+		}
+	}
+	servers := make([]Server, 0, len(found))
+	for _, s := range found {
+		servers = append(servers, s)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Ping < servers[j].Ping
+	})
+	return servers, nil
+}
+
+func newProbe() []byte {
+	p := Probe{Magic: Magic, Sent: time.Now().UnixNano()}
+	data, _ := json.Marshal(p)
+	if pad := ProbeSize - len(data) - len(`,"padding":""`); pad > 0 {
+		p.Padding = strings.Repeat(" ", pad)
+		data, _ = json.Marshal(p)
+	}
+	return data
+}
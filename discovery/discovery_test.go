@@ -0,0 +1,97 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+func TestNewProbe(t *testing.T) {
+	data := newProbe()
+	if len(data) < ProbeSize {
+		t.Errorf("probe is %d bytes, want at least %d", len(data), ProbeSize)
+	}
+	if len(data) > MaxPacket {
+		t.Errorf("probe is %d bytes, more than the %d read", len(data), MaxPacket)
+	}
+	p := Probe{}
+	if err := json.Unmarshal(data, &p); err != nil || p.Magic != Magic || p.Sent == 0 {
+		t.Errorf("bad probe %s: %v", data, err)
+	}
+}
+
+// serve answers probes on a local port with info until the test ends
+func serve(t *testing.T, info models.ServerInfoMsg) net.Addr {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go Serve(conn, func() models.ServerInfoMsg { return info })
+	return conn.LocalAddr()
+}
+
+func TestServe(t *testing.T) {
+	small := models.ServerInfoMsg{Name: "lan", Port: 4242}
+	big := models.ServerInfoMsg{Name: strings.Repeat("x", ProbeSize), Port: 4242}
+	unpadded, _ := json.Marshal(Probe{Magic: Magic, Sent: 1})
+	otherMagic, _ := json.Marshal(Probe{Magic: "other", Sent: 1, Padding: strings.Repeat(" ", ProbeSize)})
+	tests := []struct {
+		name     string
+		info     models.ServerInfoMsg
+		probe    []byte
+		answered bool
+	}{
+		{"probe", small, newProbe(), true},
+		{"unpadded probe", small, unpadded, false},
+		{"other magic", small, otherMagic, false},
+		{"not json", small, []byte(strings.Repeat("?", ProbeSize)), false},
+		{"answer larger than the probe", big, newProbe(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serve(t, tt.info)
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.WriteTo(tt.probe, addr)
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			buf := make([]byte, MaxPacket)
+			n, _, err := conn.ReadFrom(buf)
+			if answered := err == nil; answered != tt.answered {
+				t.Fatalf("answered = %v, want %v", answered, tt.answered)
+			}
+			if !tt.answered {
+				return
+			}
+			if n > len(tt.probe) {
+				t.Errorf("answer is %d bytes, larger than the %d of the probe", n, len(tt.probe))
+			}
+			answer := models.ServerInfoMsg{}
+			if err := json.Unmarshal(buf[:n], &answer); err != nil || answer.Name != tt.info.Name {
+				t.Errorf("bad answer %s: %v", buf[:n], err)
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	addr := serve(t, models.ServerInfoMsg{Name: "lan", Port: 4242})
+	// asked twice, listed once
+	servers, err := Query(nil, []string{addr.String(), addr.String()}, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 {
+		t.Fatalf("found %d servers, want 1", len(servers))
+	}
+	if s := servers[0]; s.Name != "lan" || s.Addr != "127.0.0.1:4242" || s.Ping <= 0 {
+		t.Errorf("found %+v", s)
+	}
+}
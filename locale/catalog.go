@@ -0,0 +1,405 @@
+package locale
+
+// catalog holds every user-facing string, keyed by message id.
+// Only ASCII is used since the client font atlas is text.ASCII.
+var catalog = map[string]map[Locale]string{
+	// Login
+	"login.enter_nickname": {
+		English: "Enter nickname:\n",
+		Spanish: "Ingresa tu nombre:\n",
+	},
+	"login.choose_wizard": {
+		English: "Choose wizard:\n",
+		Spanish: "Elige tu mago:\n",
+	},
+	"login.no_name": {
+		English: "The nickname was not entered correctly",
+		Spanish: "No se ingreso el nombre correctamente",
+	},
+	"login.choose_room": {
+		English: "Choose room:",
+		Spanish: "Elige una sala:",
+	},
+	"login.room": {
+		English: "%v  %v  %v players",
+		Spanish: "%v  %v  %v jugadores",
+	},
+	"login.new_room": {
+		English: "New room: %v  [%v]\nType a name, Tab changes the mode, Enter creates it",
+		Spanish: "Nueva sala: %v  [%v]\nEscribe un nombre, Tab cambia el modo, Enter la crea",
+	},
+	"login.join_timeout": {
+		English: "The server didn't answer",
+		Spanish: "El servidor no respondio",
+	},
+	"login.queued": {
+		English: "%v is full, you are number %v in the queue",
+		Spanish: "%v esta llena, eres el numero %v en la fila",
+	},
+	"login.waiting": {
+		English: "(%v waiting)",
+		Spanish: "(%v esperando)",
+	},
+
+	// Server browser
+	"browser.choose_server": {
+		English: "Choose server:",
+		Spanish: "Elige un servidor:",
+	},
+	"browser.server": {
+		English: "%v %v %v %v %v %v",
+		Spanish: "%v %v %v %v %v %v",
+	},
+	"browser.players": {
+		English: "%v players",
+		Spanish: "%v jugadores",
+	},
+	"browser.silent": {
+		English: "%v %v no answer",
+		Spanish: "%v %v no responde",
+	},
+	"browser.version": {
+		English: "%v %v %v needs version %v",
+		Spanish: "%v %v %v necesita la version %v",
+	},
+	"browser.address": {
+		English: "Address: %v\nClick a server or type host:port and press Enter, * are bookmarks",
+		Spanish: "Direccion: %v\nHaz click en un servidor o escribe host:puerto y presiona Enter, * son favoritos",
+	},
+	"browser.connecting": {
+		English: "Connecting to %v...",
+		Spanish: "Conectando a %v...",
+	},
+	"browser.failed": {
+		English: "Couldn't connect to %v",
+		Spanish: "No se pudo conectar a %v",
+	},
+	"browser.incompatible": {
+		English: "That server plays another version of the game",
+		Spanish: "Ese servidor usa otra version del juego",
+	},
+
+	// Classes
+	"class.monk": {
+		English: "Monk",
+		Spanish: "Monje",
+	},
+	"class.hunter": {
+		English: "Hunter",
+		Spanish: "Cazador",
+	},
+	"class.sniper": {
+		English: "Sniper",
+		Spanish: "Tirador",
+	},
+	"class.pyro": {
+		English: "Pyro",
+		Spanish: "Piro",
+	},
+	"class.shaman": {
+		English: "Shaman",
+		Spanish: "Chaman",
+	},
+	"class.jumper": {
+		English: "Jumper",
+		Spanish: "Saltador",
+	},
+
+	// HUD
+	"hud.typing": {
+		English: "Typing...",
+		Spanish: "Escribiendo...",
+	},
+	"hud.online": {
+		English: "Online: %v",
+		Spanish: "En linea: %v",
+	},
+	"hud.zoom_toggle": {
+		English: "Z to toggle",
+		Spanish: "Z alterna",
+	},
+	"hud.kd": {
+		English: "K/D: %v/%v",
+		Spanish: "M/M: %v/%v",
+	},
+	"hud.bot_tag": {
+		English: "%v [bot]",
+		Spanish: "%v [bot]",
+	},
+	"hud.ranking_title": {
+		English: "Top 10           K     D",
+		Spanish: "Top 10           M     M",
+	},
+
+	// Match
+	"mode.ffa": {
+		English: "Deathmatch",
+		Spanish: "Todos contra todos",
+	},
+	"mode.tdm": {
+		English: "Team deathmatch",
+		Spanish: "Equipos",
+	},
+	"mode.ctf": {
+		English: "Capture the flag",
+		Spanish: "Captura la bandera",
+	},
+	"mode.br": {
+		English: "Battle royale",
+		Spanish: "Battle royale",
+	},
+	"mode.koth": {
+		English: "King of the hill",
+		Spanish: "Rey de la colina",
+	},
+	"mode.tkoth": {
+		English: "Team king of the hill",
+		Spanish: "Rey de la colina por equipos",
+	},
+	"hill.contested": {
+		English: "Hill contested!",
+		Spanish: "Colina disputada!",
+	},
+	"hill.leader": {
+		English: "%v  %v pts",
+		Spanish: "%v  %v pts",
+	},
+	"hill.nobody": {
+		English: "Take the hill!",
+		Spanish: "Toma la colina!",
+	},
+	"zone.shrinks_in": {
+		English: "Zone shrinks in %v",
+		Spanish: "La zona se cierra en %v",
+	},
+	"zone.shrinking": {
+		English: "Zone is closing!",
+		Spanish: "La zona se esta cerrando!",
+	},
+	"zone.spectating": {
+		English: "You are out, spectating until the round ends",
+		Spanish: "Estas fuera, mirando hasta que termine la ronda",
+	},
+	"match.waiting": {
+		English: "Waiting for players",
+		Spanish: "Esperando jugadores",
+	},
+	"match.countdown": {
+		English: "%v starts in %v",
+		Spanish: "%v empieza en %v",
+	},
+	"match.running": {
+		English: "%v  %02d:%02d",
+		Spanish: "%v  %02d:%02d",
+	},
+	"match.over": {
+		English: "Match over",
+		Spanish: "Fin de la partida",
+	},
+	"match.winner": {
+		English: "Winner: %v",
+		Spanish: "Ganador: %v",
+	},
+	"match.draw": {
+		English: "Draw",
+		Spanish: "Empate",
+	},
+	"match.team_scores": {
+		English: "Red %v - %v Blue",
+		Spanish: "Rojo %v - %v Azul",
+	},
+	"match.next": {
+		English: "Next match in %v",
+		Spanish: "Proxima partida en %v",
+	},
+	"team.red": {
+		English: "Red team",
+		Spanish: "Equipo rojo",
+	},
+	"team.blue": {
+		English: "Blue team",
+		Spanish: "Equipo azul",
+	},
+
+	// Chat
+	"chat.server": {
+		English: "Server",
+		Spanish: "Servidor",
+	},
+
+	// Duels
+	"duel.countdown": {
+		English: "Duel vs %v starts in %v",
+		Spanish: "Duelo contra %v empieza en %v",
+	},
+	"duel.fighting": {
+		English: "Duel vs %v  %02d:%02d",
+		Spanish: "Duelo contra %v  %02d:%02d",
+	},
+	"duel.usage": {
+		English: "Use /duel name to challenge a player",
+		Spanish: "Usa /duel nombre para desafiar a un jugador",
+	},
+	"duel.not_found": {
+		English: "There is no player called %v here",
+		Spanish: "No hay ningun jugador llamado %v aqui",
+	},
+	"duel.self": {
+		English: "You can't duel yourself",
+		Spanish: "No puedes batirte a duelo contigo mismo",
+	},
+	"duel.bot": {
+		English: "Bots don't duel",
+		Spanish: "Los bots no se baten a duelo",
+	},
+	"duel.busy": {
+		English: "One of you is already in a duel",
+		Spanish: "Uno de ustedes ya esta en un duelo",
+	},
+	"duel.sent": {
+		English: "You challenged %v to a duel",
+		Spanish: "Desafiaste a %v a un duelo",
+	},
+	"duel.received": {
+		English: "%v challenges you to a duel, type /accept to fight",
+		Spanish: "%v te desafia a un duelo, escribe /accept para pelear",
+	},
+	"duel.no_challenge": {
+		English: "Nobody challenged you, or the challenge expired",
+		Spanish: "Nadie te desafio, o el desafio expiro",
+	},
+	"duel.started": {
+		English: "%v and %v start a duel",
+		Spanish: "%v y %v empiezan un duelo",
+	},
+	"duel.won": {
+		English: "%v beat %v in a duel (%v-%v vs %v-%v)",
+		Spanish: "%v le gano a %v en un duelo (%v-%v contra %v-%v)",
+	},
+	"duel.draw": {
+		English: "The duel between %v and %v ended in a draw",
+		Spanish: "El duelo entre %v y %v termino en empate",
+	},
+	"command.unknown": {
+		English: "Unknown command %v",
+		Spanish: "Comando desconocido %v",
+	},
+
+	// Spectators
+	"spectate.watching": {
+		English: "Watching %v (%v)  HP %v/%v  MP %v/%v",
+		Spanish: "Mirando a %v (%v)  HP %v/%v  MP %v/%v",
+	},
+	"spectate.free": {
+		English: "Free camera",
+		Spanish: "Camara libre",
+	},
+	"spectate.keys": {
+		English: "Tab: next player  F: free camera  /spectate: leave",
+		Spanish: "Tab: siguiente jugador  F: camara libre  /spectate: salir",
+	},
+	"spectate.on": {
+		English: "You are spectating, type /spectate again to play",
+		Spanish: "Estas mirando, escribe /spectate de nuevo para jugar",
+	},
+	"spectate.off": {
+		English: "You are back in the game, revive at the priest",
+		Spanish: "Volviste al juego, revive con el sacerdote",
+	},
+
+	// Replays
+	"replay.viewer": {
+		English: "viewer",
+		Spanish: "espectador",
+	},
+	"replay.status": {
+		English: "Replay %v as %v  %v / %v  x%v",
+		Spanish: "Repeticion %v como %v  %v / %v  x%v",
+	},
+	"replay.paused": {
+		English: "  (paused)",
+		Spanish: "  (pausa)",
+	},
+	"replay.keys": {
+		English: "Space: pause  +/-: speed  [ ]: seek  P: player",
+		Spanish: "Espacio: pausa  +/-: velocidad  [ ]: adelantar/atrasar  P: jugador",
+	},
+	"replay.clock": {
+		English: "%02d:%02d",
+		Spanish: "%02d:%02d",
+	},
+
+	// Lobby
+	"lobby.no_room": {
+		English: "That room doesn't exist anymore",
+		Spanish: "Esa sala ya no existe",
+	},
+	"lobby.room_exists": {
+		English: "There is already a room with that name",
+		Spanish: "Ya hay una sala con ese nombre",
+	},
+	"lobby.bad_name": {
+		English: "Room names have 1 to 20 plain characters and no slashes",
+		Spanish: "Los nombres de sala tienen de 1 a 20 caracteres simples y ninguna barra",
+	},
+	"lobby.too_many_rooms": {
+		English: "The server can't host more rooms",
+		Spanish: "El servidor no puede tener mas salas",
+	},
+	"lobby.bad_mode": {
+		English: "Unknown game mode",
+		Spanish: "Modo de juego desconocido",
+	},
+	"lobby.room_full": {
+		English: "That room is full",
+		Spanish: "Esa sala esta llena",
+	},
+
+	// Server system messages
+	"system.joined": {
+		English: "%v joined the game",
+		Spanish: "%v entro al juego",
+	},
+	"system.left": {
+		English: "%v left the game",
+		Spanish: "%v salio del juego",
+	},
+	"system.flag_taken": {
+		English: "%v took the enemy flag",
+		Spanish: "%v tomo la bandera enemiga",
+	},
+	"system.flag_dropped": {
+		English: "%v dropped the flag",
+		Spanish: "%v solto la bandera",
+	},
+	"system.flag_returned": {
+		English: "%v returned their flag",
+		Spanish: "%v recupero su bandera",
+	},
+	"system.flag_captured": {
+		English: "%v captured the flag",
+		Spanish: "%v capturo la bandera",
+	},
+	"system.shutdown": {
+		English: "The server shuts down in %v seconds",
+		Spanish: "El servidor se apaga en %v segundos",
+	},
+
+	// Admin
+	"admin.kicked": {
+		English: "You were kicked from the server",
+		Spanish: "Fuiste expulsado del servidor",
+	},
+	"admin.kicked_reason": {
+		English: "You were kicked from the server: %v",
+		Spanish: "Fuiste expulsado del servidor: %v",
+	},
+	"admin.announce": {
+		English: "[server] %v",
+		Spanish: "[servidor] %v",
+	},
+	"admin.ranking_reset": {
+		English: "The ranking was reset",
+		Spanish: "El ranking fue reiniciado",
+	},
+}
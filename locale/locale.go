@@ -0,0 +1,46 @@
+package locale
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Locale identifies a language of the message catalog
+type Locale string
+
+// Supported locales
+const (
+	English Locale = "en"
+	Spanish Locale = "es"
+)
+
+// Default is used when a locale is unknown or a key has no translation
+var Default = English
+
+// Parse turns a settings or handshake value into a supported Locale,
+// falling back to Default
+func Parse(s string) Locale {
+	l := Locale(strings.ToLower(strings.TrimSpace(s)))
+	switch l {
+	case English, Spanish:
+		return l
+	}
+	return Default
+}
+
+// T looks up key in the catalog for l and formats it with args.
+// Missing translations fall back to Default, missing keys to the key itself.
+func T(l Locale, key string, args ...interface{}) string {
+	msg, ok := catalog[key]
+	if !ok {
+		return key
+	}
+	s, ok := msg[l]
+	if !ok {
+		s = msg[Default]
+	}
+	if len(args) == 0 {
+		return s
+	}
+	return fmt.Sprintf(s, args...)
+}
@@ -0,0 +1,92 @@
+// Package logging sets up the levelled, structured logs of the server and
+// the client on top of log/slog. Once set up, the standard log package
+// writes through the same handler.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Options say how much is logged, how and where
+type Options struct {
+	Level  string // debug, info, warn or error, info if empty
+	Format string // text or json, text if empty
+	Output io.Writer
+}
+
+// ParseLevel reads debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// level is shared by the loggers built by Setup so SetLevel changes it
+// while running
+var level = &slog.LevelVar{}
+
+// SetLevel changes the level of the default logger
+func SetLevel(s string) error {
+	l, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// New builds a logger from o
+func New(o Options) (*slog.Logger, error) {
+	l, err := ParseLevel(o.Level)
+	if err != nil {
+		return nil, err
+	}
+	lv := &slog.LevelVar{}
+	lv.Set(l)
+	return newLogger(o, lv)
+}
+
+func newLogger(o Options, lv *slog.LevelVar) (*slog.Logger, error) {
+	if o.Output == nil {
+		o.Output = os.Stderr
+	}
+	ho := &slog.HandlerOptions{Level: lv}
+	switch strings.ToLower(o.Format) {
+	case "text", "":
+		return slog.New(slog.NewTextHandler(o.Output, ho)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(o.Output, ho)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, use text or json", o.Format)
+}
+
+// Setup builds a logger from o and makes it the default one
+func Setup(o Options) (*slog.Logger, error) {
+	if err := SetLevel(o.Level); err != nil {
+		return nil, err
+	}
+	l, err := newLogger(o, level)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(l)
+	return l, nil
+}
+
+// Fatal logs msg as an error and exits
+func Fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
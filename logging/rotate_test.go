@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string // already in the file when opened
+		maxSize  int64
+		backups  int
+		writes   []string
+		files    map[string]string // by suffix of the path, "-" for none
+	}{
+		{
+			name:    "under the size",
+			maxSize: 100,
+			backups: 2,
+			writes:  []string{"one\n", "two\n"},
+			files:   map[string]string{"": "one\ntwo\n", ".1": "-"},
+		},
+		{
+			name:    "backups shift",
+			maxSize: 6,
+			backups: 2,
+			writes:  []string{"one\n", "two\n", "three\n", "four\n"},
+			files:   map[string]string{"": "four\n", ".1": "three\n", ".2": "two\n", ".3": "-"},
+		},
+		{
+			name:    "no backups",
+			maxSize: 6,
+			backups: 0,
+			writes:  []string{"one\n", "two\n"},
+			files:   map[string]string{"": "two\n", ".1": "-"},
+		},
+		{
+			name:    "no limit",
+			maxSize: 0,
+			backups: 2,
+			writes:  []string{"one\n", "two\n"},
+			files:   map[string]string{"": "one\ntwo\n", ".1": "-"},
+		},
+		{
+			name:     "existing size counts",
+			existing: "old\n",
+			maxSize:  6,
+			backups:  1,
+			writes:   []string{"new\n"},
+			files:    map[string]string{"": "new\n", ".1": "old\n"},
+		},
+		{
+			name:    "write larger than the size",
+			maxSize: 2,
+			backups: 1,
+			writes:  []string{"one\n", "two\n"},
+			files:   map[string]string{"": "two\n", ".1": "one\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "logs", "server.log")
+			if tt.existing != "" {
+				os.MkdirAll(filepath.Dir(path), 0755)
+				ioutil.WriteFile(path, []byte(tt.existing), 0644)
+			}
+			f, err := OpenRotatingFile(path, tt.maxSize, tt.backups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				if _, err := fmt.Fprint(f, w); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f.Close()
+			for suffix, want := range tt.files {
+				data, err := ioutil.ReadFile(path + suffix)
+				if want == "-" {
+					if !os.IsNotExist(err) {
+						t.Errorf("%v exists", filepath.Base(path+suffix))
+					}
+					continue
+				}
+				if string(data) != want {
+					t.Errorf("%v = %q, want %q", filepath.Base(path+suffix), data, want)
+				}
+			}
+		})
+	}
+}
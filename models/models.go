@@ -2,10 +2,20 @@ package models
 
 import (
 	"encoding/json"
+	"math"
 
 	"github.com/segmentio/ksuid"
 )
 
+// ProtocolVersion is bumped whenever events or messages change in a way
+// older clients or replays can't follow. 2 changed the player updates,
+// system messages, the handshake and joining rooms.
+const ProtocolVersion = 2
+
+// ForestSeed offsets the random tree layout of the forest map, clients and
+// replays have to agree on it
+const ForestSeed int64 = 0
+
 // Event type represents a message type where:
 // 	-UpdateClient: client <- server
 // 	-UpdateServer: client -> server
@@ -22,12 +32,65 @@ const (
 	UpdateRanking
 	ConfirmIDReception
 	Disconect
+	System
+	Rules
+	Match
+	Flags
+	Zone
+	Damage
+	Hill
+	Lobby
+	JoinRoom
+	Duel
+	Ping
 )
 
 func (d Event) String() string {
-	return [...]string{"UpdateClient", "UpdateServer", "Spell", "Chat", "Death", "UpdateRanking", "Disconect"}[d]
+	return [...]string{"UpdateClient", "UpdateServer", "Spell", "Chat", "Death", "UpdateRanking", "ConfirmIDReception", "Disconect", "System", "Rules", "Match", "Flags", "Zone", "Damage", "Hill", "Lobby", "JoinRoom", "Duel", "Ping"}[d]
 }
 
+// Team a player belongs to, players with NoTeam are hostile to everyone
+type Team int
+
+// Teams
+const (
+	NoTeam Team = iota
+	RedTeam
+	BlueTeam
+)
+
+// MatchState is the phase a match is in
+type MatchState int
+
+// Match states
+const (
+	MatchWaiting MatchState = iota
+	MatchCountdown
+	MatchRunning
+	MatchEnded
+)
+
+// FlagState is where a capture-the-flag flag is
+type FlagState int
+
+// Flag states
+const (
+	FlagAtBase FlagState = iota
+	FlagCarried
+	FlagDropped
+)
+
+// DuelState is the phase of a 1v1 duel
+type DuelState int
+
+// Duel states
+const (
+	DuelNone DuelState = iota
+	DuelCountdown
+	DuelFighting
+	DuelEnded
+)
+
 type Mesg struct {
 	Type    Event           `json:"event"`
 	Payload json.RawMessage `json:"payload"`
@@ -50,6 +113,32 @@ func UnmarshallMesg(m []byte) *Mesg {
 	return r
 }
 
+// PingMsg is sent by the server and echoed by the client to measure the
+// round trip time
+type PingMsg struct {
+	Sent int64 `json:"sent"` // unix nanoseconds
+}
+
+// HandshakeMsg is sent by the client along with ConfirmIDReception
+type HandshakeMsg struct {
+	Locale string `json:"locale"`
+	// BypassToken lets the client in full rooms
+	BypassToken string `json:"bypass_token,omitempty"`
+	// Profile is a random id kept in the client profile, the server gives
+	// the player its ranking place back by it after a restart
+	Profile string `json:"profile,omitempty"`
+}
+
+// RulesMsg tells the client how spells must be resolved
+type RulesMsg struct {
+	FriendlyFire bool `json:"friendly_fire"`
+}
+
+// SystemMsg is a server notice already localised for the receiving client
+type SystemMsg struct {
+	Text string `json:"text"`
+}
+
 type DisconectMsg struct {
 	ID ksuid.KSUID `json:"id"`
 }
@@ -76,6 +165,17 @@ type PlayerMsg struct {
 	Moving    bool        `json:"moving"`
 	Dead      bool        `json:"dead"`
 	Invisible bool        `json:"invisible"`
+	Team      Team        `json:"team"`
+	MP        float64     `json:"mp"`
+	Class     int         `json:"class"`
+	// Spectator players are out of the game, nothing collides with them or
+	// targets them
+	Spectator bool `json:"spectator"`
+	// Duel is the duel the player is in, duelists only hit each other
+	Duel         ksuid.KSUID `json:"duel"`
+	DuelFighting bool        `json:"duel_fighting"`
+	// Bot players are played by the server
+	Bot bool `json:"bot"`
 }
 
 type ChatMsg struct {
@@ -96,4 +196,132 @@ type RankingPosMsg struct {
 	ID   ksuid.KSUID `json:"id"`
 	K    int         `json:"kills"`
 	D    int         `json:"deaths"`
+	Bot  bool        `json:"bot"`
+}
+
+// MatchMsg is broadcast every second with the state of the current match
+type MatchMsg struct {
+	Mode      string          `json:"mode"`
+	State     MatchState      `json:"state"`
+	Remaining float64         `json:"remaining"` // seconds until the state changes
+	NoRespawn bool            `json:"no_respawn"`
+	Result    *MatchResultMsg `json:"result,omitempty"`
+}
+
+type MatchResultMsg struct {
+	WinnerID   ksuid.KSUID      `json:"winner_id"`
+	WinnerName string           `json:"winner_name"`
+	WinnerTeam Team             `json:"winner_team"`
+	TeamScores map[Team]int     `json:"team_scores,omitempty"`
+	Standings  []*RankingPosMsg `json:"standings"`
+}
+
+// FlagMsg is the state of one team flag, Flags events carry a []FlagMsg
+type FlagMsg struct {
+	Team    Team        `json:"team"`
+	State   FlagState   `json:"state"`
+	Carrier ksuid.KSUID `json:"carrier"`
+	X       float64     `json:"x"`
+	Y       float64     `json:"y"`
+	BaseX   float64     `json:"base_x"`
+	BaseY   float64     `json:"base_y"`
+	Score   int         `json:"score"` // captures made by Team
+}
+
+// ZoneMsg is the battle royale safe zone, outside of it players take damage
+type ZoneMsg struct {
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+	Radius     float64 `json:"radius"`
+	NextX      float64 `json:"next_x"`
+	NextY      float64 `json:"next_y"`
+	NextRadius float64 `json:"next_radius"`
+	NextShrink float64 `json:"next_shrink"` // seconds until it starts shrinking, 0 while shrinking
+	Damage     float64 `json:"damage"`      // per second outside of the zone
+}
+
+// DamageMsg is damage dealt by the server to the receiving client
+type DamageMsg struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+// HillHolder is who stands on, captures or controls the hill: a team in team
+// modes or a single player otherwise. The zero value is nobody.
+type HillHolder struct {
+	ID   ksuid.KSUID `json:"id"`
+	Team Team        `json:"team"`
+	Name string      `json:"name"`
+}
+
+type HillScoreMsg struct {
+	HillHolder
+	Points int `json:"points"`
+}
+
+// HillMsg is the king-of-the-hill control point state
+type HillMsg struct {
+	X          float64        `json:"x"`
+	Y          float64        `json:"y"`
+	Radius     float64        `json:"radius"`
+	Controller HillHolder     `json:"controller"`
+	Capturer   HillHolder     `json:"capturer"`
+	Progress   float64        `json:"progress"` // 0 to 1, capturer takes control at 1
+	Contested  bool           `json:"contested"`
+	Scores     []HillScoreMsg `json:"scores"`
+}
+
+// RoomMsg is a room as listed in the lobby, clients send an empty Lobby
+// message to ask for the list again
+type RoomMsg struct {
+	Name       string `json:"name"`
+	Mode       string `json:"mode"`
+	Map        string `json:"map"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"` // 0 for no limit
+	Queued     int    `json:"queued"`      // waiting for a place
+}
+
+// ServerInfoMsg answers a discovery probe. It goes over UDP outside the
+// event stream, Sent echoes the probe so the client measures the ping.
+type ServerInfoMsg struct {
+	Name       string `json:"name"`
+	Mode       string `json:"mode"` // of the first room
+	Rooms      int    `json:"rooms"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"` // per room, 0 for no limit
+	Version    int    `json:"version"`     // ProtocolVersion of the server
+	Port       int    `json:"port"`        // the game port, TCP
+	Sent       int64  `json:"sent"`
+}
+
+// JoinRoomMsg asks to join a room, or to create it with Mode when Create is
+// set. The server answers with the same message, Error is set if it failed.
+// With Queue a full room doesn't fail the join: the answers carry the
+// position in the queue in Queued until one comes without it, the join.
+type JoinRoomMsg struct {
+	Room   string `json:"room"`
+	Mode   string `json:"mode"`
+	Create bool   `json:"create"`
+	Queue  bool   `json:"queue"`
+	Queued int    `json:"queued,omitempty"`
+	Error  string `json:"error"`
+}
+
+// DuelMsg is sent to both duelists whenever their duel changes state,
+// X and Y are the spot the receiver is moved to
+type DuelMsg struct {
+	ID           ksuid.KSUID `json:"id"`
+	State        DuelState   `json:"state"`
+	Opponent     ksuid.KSUID `json:"opponent"`
+	OpponentName string      `json:"opponent_name"`
+	X            float64     `json:"x"`
+	Y            float64     `json:"y"`
+	Remaining    float64     `json:"remaining"`
+	Winner       ksuid.KSUID `json:"winner"`
+}
+
+// InsideRadius reports if the point x, y is within r of the center cx, cy
+func InsideRadius(cx, cy, x, y, r float64) bool {
+	return math.Hypot(cx-x, cy-y) <= r
 }
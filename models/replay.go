@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// A replay file is a gzip compressed stream of json lines: the ReplayHeader
+// first and then one ReplayEntry per message the server got or sent.
+
+// ReplayDirection tells if a recorded message came from or went to a client
+type ReplayDirection string
+
+// Replay directions
+const (
+	ReplayIn  ReplayDirection = "in"
+	ReplayOut ReplayDirection = "out"
+)
+
+type ReplayHeader struct {
+	Version int               `json:"version"`
+	Room    string            `json:"room"`
+	Mode    string            `json:"mode"`
+	Map     string            `json:"map"`
+	Seed    int64             `json:"seed"`
+	Started time.Time         `json:"started"`
+	Roster  []ReplayRosterMsg `json:"roster"`
+}
+
+// ReplayRosterMsg is a player connected when the recording started
+type ReplayRosterMsg struct {
+	ID    ksuid.KSUID `json:"id"`
+	Name  string      `json:"name"`
+	Team  Team        `json:"team"`
+	Class int         `json:"class"`
+}
+
+type ReplayEntry struct {
+	// At is the time since the recording started, in milliseconds
+	At     int64           `json:"at"`
+	Dir    ReplayDirection `json:"dir"`
+	Client ksuid.KSUID     `json:"client"`
+	Mesg   json.RawMessage `json:"mesg"`
+}
@@ -0,0 +1,118 @@
+// Package registry is the protocol of the master server: game servers send
+// signed heartbeats to it and clients ask it for the servers alive.
+//
+// Heartbeats are POSTed as json to /heartbeat with an X-Goao-Signature
+// header, the hex HMAC-SHA256 of the body with the secret shared by the
+// master and the game servers. GET /servers lists the servers as json.
+package registry
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+const (
+	SignatureHeader = "X-Goao-Signature"
+	// HeartbeatInterval is how often game servers send heartbeats
+	HeartbeatInterval = 30 * time.Second
+	// MaxClockSkew is how old or ahead a heartbeat can be, older ones may
+	// be replayed
+	MaxClockSkew = time.Minute
+	// MaxBody is the largest heartbeat read
+	MaxBody = 4096
+	Timeout = 5 * time.Second
+)
+
+var (
+	ErrSignature = errors.New("bad signature")
+	ErrStale     = errors.New("heartbeat too old or from the future")
+)
+
+// Heartbeat keeps a game server listed. Addr is where players connect,
+// when empty the master uses the address the heartbeat came from and Port.
+type Heartbeat struct {
+	models.ServerInfoMsg
+	Addr string `json:"addr,omitempty"`
+	Time int64  `json:"time"` // unix seconds
+}
+
+// Entry is a server listed by the master
+type Entry struct {
+	models.ServerInfoMsg
+	Addr     string    `json:"addr"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Sign is the signature of body with secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of body and that the heartbeat is recent
+func Verify(secret, signature string, body []byte) (Heartbeat, error) {
+	hb := Heartbeat{}
+	want := Sign(secret, body)
+	if !hmac.Equal([]byte(want), []byte(strings.ToLower(signature))) {
+		return hb, ErrSignature
+	}
+	if err := json.Unmarshal(body, &hb); err != nil {
+		return hb, err
+	}
+	skew := time.Since(time.Unix(hb.Time, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return hb, ErrStale
+	}
+	return hb, nil
+}
+
+var client = &http.Client{Timeout: Timeout}
+
+// Send signs hb with secret and sends it to the master at url
+func Send(url, secret string, hb Heartbeat) error {
+	hb.Time = time.Now().Unix()
+	body, _ := json.Marshal(hb)
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(url, "/")+"/heartbeat", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(secret, body))
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("master answered %v: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// List asks the master at url for the servers alive
+func List(url string) ([]Entry, error) {
+	res, err := client.Get(strings.TrimSuffix(url, "/") + "/servers")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("master answered %v", res.Status)
+	}
+	entries := []Entry{}
+	err = json.NewDecoder(res.Body).Decode(&entries)
+	return entries, err
+}
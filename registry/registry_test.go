@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	body := func(at time.Time) []byte {
+		hb := Heartbeat{Addr: "example.com:33333", Time: at.Unix()}
+		data, _ := json.Marshal(hb)
+		return data
+	}
+	now := time.Now()
+	tests := []struct {
+		name      string
+		body      []byte
+		signature func(body []byte) string
+		err       error
+	}{
+		{"signed", body(now), func(b []byte) string { return Sign("secret", b) }, nil},
+		{"upper case signature", body(now), func(b []byte) string { return strings.ToUpper(Sign("secret", b)) }, nil},
+		{"other secret", body(now), func(b []byte) string { return Sign("other", b) }, ErrSignature},
+		{"no signature", body(now), func(b []byte) string { return "" }, ErrSignature},
+		{"signature of another body", body(now), func(b []byte) string { return Sign("secret", body(now.Add(time.Second))) }, ErrSignature},
+		{"skew within limit", body(now.Add(-MaxClockSkew / 2)), func(b []byte) string { return Sign("secret", b) }, nil},
+		{"stale", body(now.Add(-2 * MaxClockSkew)), func(b []byte) string { return Sign("secret", b) }, ErrStale},
+		{"from the future", body(now.Add(2 * MaxClockSkew)), func(b []byte) string { return Sign("secret", b) }, ErrStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb, err := Verify("secret", tt.signature(tt.body), tt.body)
+			if err != tt.err {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && hb.Addr != "example.com:33333" {
+				t.Errorf("Verify() addr = %q", hb.Addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,547 @@
+// Package sdk is a client for go-pixel-ao servers that needs no window.
+// It does the handshake, joins a room and turns server events into typed
+// callbacks, for bots, moderation tools and test harnesses.
+//
+//	c, err := sdk.Dial("localhost:33333", sdk.Options{Name: "helper"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+//	c.OnChat(func(m models.ChatMsg) { log.Println(m.Name, m.Message) })
+//	if err := c.Join("main"); err != nil {
+//		log.Fatal(err)
+//	}
+//	c.Say("hello")
+//	<-c.Done()
+package sdk
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// DefaultUpdateRate is how often the player state is sent, the server
+	// answers each update with the other players
+	DefaultUpdateRate = time.Second / 20
+	// ReplyTimeout is how long Rooms and Join wait for the server
+	ReplyTimeout = 10 * time.Second
+)
+
+// Wizard classes, numbered like WizardType in the game client
+const (
+	DarkWizard = iota
+	Monk
+	Shaman
+	Sniper
+	Timewreker
+	Hunter
+)
+
+// SpellTypes maps the spells the game client knows to their type
+var SpellTypes = map[string]string{
+	"apoca":       "on-target",
+	"desca":       "on-target",
+	"explo":       "on-target",
+	"fireball":    "projectile",
+	"icesnipe":    "projectile",
+	"healshot":    "projectile",
+	"manashot":    "projectile",
+	"rockshot":    "projectile",
+	"arrowshot":   "casted-projectile",
+	"lava-spot":   "aoe",
+	"heal-spot":   "aoe",
+	"mana-spot":   "aoe",
+	"hunter-trap": "trap",
+}
+
+var (
+	ErrClosed       = errors.New("sdk: connection closed")
+	ErrTimeout      = errors.New("sdk: server did not answer")
+	ErrNotInRoom    = errors.New("sdk: join a room first")
+	ErrUnknownSpell = errors.New("sdk: unknown spell")
+)
+
+// Options describe the wizard the client plays
+type Options struct {
+	Name   string
+	Class  int
+	Skin   int
+	Locale string // locale system messages come in, "en" by default
+	// BypassToken is the bypass token of the server, to get in full rooms
+	BypassToken string
+	// Profile identifies the player across server restarts, to get its
+	// ranking place back, none if empty
+	Profile string
+	// X and Y are where the player starts, the priest by default
+	X, Y float64
+	// UpdateRate is how often the state is sent, DefaultUpdateRate if
+	// zero. A negative rate sends it only on Move and Update.
+	UpdateRate time.Duration
+}
+
+// Stats counts the traffic of a Client
+type Stats struct {
+	Sent, Received           int64 // messages
+	BytesSent, BytesReceived int64
+}
+
+// Client is a connection to a server. Callbacks run in the goroutine that
+// reads from the server, they must not block.
+type Client struct {
+	// first so the atomic counters are aligned on 32 bit platforms
+	stats Stats
+
+	ID ksuid.KSUID
+
+	conn    net.Conn
+	out     chan []byte
+	done    chan struct{}
+	err     error
+	closing sync.Once
+
+	mutex  *sync.Mutex
+	state  models.PlayerMsg
+	inRoom bool
+	rate   time.Duration
+
+	rooms chan []models.RoomMsg
+	joins chan models.JoinRoomMsg
+
+	onPlayers func([]models.PlayerMsg)
+	onSpell   func(models.SpellMsg)
+	onChat    func(models.ChatMsg)
+	onDeath   func(models.DeathMsg)
+	onRanking func([]models.RankingPosMsg)
+	onSystem  func(models.SystemMsg)
+}
+
+// Dial connects to addr and does the handshake, the client is left in the
+// lobby until it joins a room
+func Dial(addr string, opts Options) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, ReplyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Locale == "" {
+		opts.Locale = "en"
+	}
+	if opts.X == 0 && opts.Y == 0 {
+		opts.X, opts.Y = 2000, 2900
+	}
+	c := &Client{
+		conn:  conn,
+		out:   make(chan []byte, 512),
+		done:  make(chan struct{}),
+		mutex: &sync.Mutex{},
+		rate:  opts.UpdateRate,
+		rooms: make(chan []models.RoomMsg, 1),
+		joins: make(chan models.JoinRoomMsg, 1),
+	}
+	if c.rate == 0 {
+		c.rate = DefaultUpdateRate
+	}
+
+	// the server sends the id as a plain line before anything else
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(ReplyTimeout))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if c.ID, err = ksuid.Parse(trimLine(line)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sdk: bad client id %q: %v", trimLine(line), err)
+	}
+	c.state = models.PlayerMsg{
+		ID:    c.ID,
+		Name:  opts.Name,
+		Skin:  opts.Skin,
+		Class: opts.Class,
+		HP:    347,
+		MP:    2324,
+		X:     opts.X,
+		Y:     opts.Y,
+		Dir:   "down",
+	}
+
+	go c.writer()
+	go c.reader(r)
+	hs, _ := json.Marshal(models.HandshakeMsg{Locale: opts.Locale, BypassToken: opts.BypassToken, Profile: opts.Profile})
+	c.send(models.ConfirmIDReception, hs)
+	return c, nil
+}
+
+func trimLine(s string) string {
+	for len(s) > 0 && (s[len(s)-1] == '\n' || s[len(s)-1] == '\r') {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// OnPlayers is called with every player in the room but this one, the
+// server sends them in answer to each state update
+func (c *Client) OnPlayers(f func([]models.PlayerMsg)) {
+	c.mutex.Lock()
+	c.onPlayers = f
+	c.mutex.Unlock()
+}
+
+// OnSpell is called for the spells others cast
+func (c *Client) OnSpell(f func(models.SpellMsg)) {
+	c.mutex.Lock()
+	c.onSpell = f
+	c.mutex.Unlock()
+}
+
+// OnChat is called for the chat messages of the room
+func (c *Client) OnChat(f func(models.ChatMsg)) {
+	c.mutex.Lock()
+	c.onChat = f
+	c.mutex.Unlock()
+}
+
+// OnDeath is called for every kill in the room
+func (c *Client) OnDeath(f func(models.DeathMsg)) {
+	c.mutex.Lock()
+	c.onDeath = f
+	c.mutex.Unlock()
+}
+
+// OnRanking is called once a second with the room ranking
+func (c *Client) OnRanking(f func([]models.RankingPosMsg)) {
+	c.mutex.Lock()
+	c.onRanking = f
+	c.mutex.Unlock()
+}
+
+// OnSystem is called for the server notices
+func (c *Client) OnSystem(f func(models.SystemMsg)) {
+	c.mutex.Lock()
+	c.onSystem = f
+	c.mutex.Unlock()
+}
+
+// Rooms asks the server for the list of rooms
+func (c *Client) Rooms() ([]models.RoomMsg, error) {
+	if err := c.send(models.Lobby, nil); err != nil {
+		return nil, err
+	}
+	select {
+	case rooms := <-c.rooms:
+		return rooms, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-time.After(ReplyTimeout):
+		return nil, ErrTimeout
+	}
+}
+
+// Join enters an existing room
+func (c *Client) Join(room string) error {
+	return c.join(models.JoinRoomMsg{Room: room})
+}
+
+// Create makes a new room playing mode and enters it
+func (c *Client) Create(room, mode string) error {
+	return c.join(models.JoinRoomMsg{Room: room, Mode: mode, Create: true})
+}
+
+func (c *Client) join(req models.JoinRoomMsg) error {
+	payload, _ := json.Marshal(req)
+	if err := c.send(models.JoinRoom, payload); err != nil {
+		return err
+	}
+	select {
+	case reply := <-c.joins:
+		if reply.Error != "" {
+			return errors.New(reply.Error)
+		}
+	case <-c.done:
+		return c.Err()
+	case <-time.After(ReplyTimeout):
+		return ErrTimeout
+	}
+	c.mutex.Lock()
+	c.inRoom = true
+	c.mutex.Unlock()
+	// players show up in the room with their first update, game clients
+	// expect it before any chat or spell
+	if err := c.sendState(); err != nil {
+		return err
+	}
+	if c.rate > 0 {
+		go c.updater()
+	}
+	return nil
+}
+
+// State is the last state sent for the player
+func (c *Client) State() models.PlayerMsg {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// Move places the player at x, y facing dir ("up", "down", "left" or
+// "right"), moving tells others to animate the walk
+func (c *Client) Move(x, y float64, dir string, moving bool) error {
+	c.mutex.Lock()
+	c.state.X, c.state.Y = x, y
+	c.state.Dir, c.state.Moving = dir, moving
+	c.mutex.Unlock()
+	return c.sendState()
+}
+
+// Update changes the player state with f and sends it, for anything Move
+// doesn't cover like the health or the mana
+func (c *Client) Update(f func(p *models.PlayerMsg)) error {
+	c.mutex.Lock()
+	f(&c.state)
+	c.state.ID = c.ID
+	c.mutex.Unlock()
+	return c.sendState()
+}
+
+// Cast throws spell towards x, y. On-target spells need CastOn.
+func (c *Client) Cast(spell string, x, y float64) error {
+	return c.cast(spell, x, y, ksuid.Nil, 0)
+}
+
+// CastOn casts an on-target spell on the player with id
+func (c *Client) CastOn(spell string, target ksuid.KSUID) error {
+	return c.cast(spell, 0, 0, target, 0)
+}
+
+// Shoot releases an arrow charged for charge seconds towards x, y
+func (c *Client) Shoot(x, y, charge float64) error {
+	return c.cast("arrowshot", x, y, ksuid.Nil, charge)
+}
+
+func (c *Client) cast(spell string, x, y float64, target ksuid.KSUID, charge float64) error {
+	t, ok := SpellTypes[spell]
+	if !ok {
+		return ErrUnknownSpell
+	}
+	if !c.joined() {
+		return ErrNotInRoom
+	}
+	s := c.State()
+	payload, _ := json.Marshal(models.SpellMsg{
+		ID:         c.ID,
+		SpellType:  t,
+		SpellName:  spell,
+		TargetID:   target,
+		Name:       s.Name,
+		X:          x,
+		Y:          y,
+		ChargeTime: charge,
+	})
+	return c.send(models.Spell, payload)
+}
+
+// Say writes in the room chat, messages starting with / are commands
+func (c *Client) Say(text string) error {
+	if !c.joined() {
+		return ErrNotInRoom
+	}
+	payload, _ := json.Marshal(models.ChatMsg{ID: c.ID, Name: c.State().Name, Message: text})
+	return c.send(models.Chat, payload)
+}
+
+// Stats returns the traffic so far
+func (c *Client) Stats() Stats {
+	return Stats{
+		Sent:          atomic.LoadInt64(&c.stats.Sent),
+		Received:      atomic.LoadInt64(&c.stats.Received),
+		BytesSent:     atomic.LoadInt64(&c.stats.BytesSent),
+		BytesReceived: atomic.LoadInt64(&c.stats.BytesReceived),
+	}
+}
+
+// Done is closed when the connection is lost or closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err is why the connection was lost, nil while it is open
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close disconnects from the server
+func (c *Client) Close() error {
+	c.close(ErrClosed)
+	return nil
+}
+
+func (c *Client) close(err error) {
+	c.closing.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *Client) joined() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.inRoom
+}
+
+func (c *Client) sendState() error {
+	if !c.joined() {
+		return ErrNotInRoom
+	}
+	payload, _ := json.Marshal(c.State())
+	return c.send(models.UpdateServer, payload)
+}
+
+func (c *Client) send(t models.Event, payload json.RawMessage) error {
+	select {
+	case c.out <- models.NewMesg(t, payload):
+		return nil
+	case <-c.done:
+		return c.Err()
+	}
+}
+
+// updater keeps sending the state, the server only sends the other
+// players back as an answer
+func (c *Client) updater() {
+	tick := time.NewTicker(c.rate)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			c.sendState()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) writer() {
+	w := bufio.NewWriter(c.conn)
+	for {
+		select {
+		case msg := <-c.out:
+			w.Write(msg)
+			w.WriteByte('\n')
+			if err := w.Flush(); err != nil {
+				c.close(err)
+				return
+			}
+			atomic.AddInt64(&c.stats.Sent, 1)
+			atomic.AddInt64(&c.stats.BytesSent, int64(len(msg)+1))
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) reader(r *bufio.Reader) {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			c.close(err)
+			return
+		}
+		atomic.AddInt64(&c.stats.Received, 1)
+		atomic.AddInt64(&c.stats.BytesReceived, int64(len(line)))
+		msg := models.Mesg{}
+		// the id may be sent again before the handshake gets through
+		if err := json.Unmarshal(line, &msg); err != nil {
+			continue
+		}
+		c.dispatch(msg)
+	}
+}
+
+func (c *Client) dispatch(msg models.Mesg) {
+	c.mutex.Lock()
+	onPlayers, onSpell, onChat := c.onPlayers, c.onSpell, c.onChat
+	onDeath, onRanking, onSystem := c.onDeath, c.onRanking, c.onSystem
+	c.mutex.Unlock()
+
+	switch msg.Type {
+	case models.Lobby:
+		rooms := []models.RoomMsg{}
+		if json.Unmarshal(msg.Payload, &rooms) == nil {
+			// only the latest list is kept
+			select {
+			case <-c.rooms:
+			default:
+			}
+			c.rooms <- rooms
+		}
+	case models.JoinRoom:
+		reply := models.JoinRoomMsg{}
+		if json.Unmarshal(msg.Payload, &reply) == nil {
+			// a reply that came after Join gave up is dropped
+			select {
+			case <-c.joins:
+			default:
+			}
+			c.joins <- reply
+		}
+	case models.UpdateClient:
+		players := []models.PlayerMsg{}
+		if onPlayers != nil && json.Unmarshal(msg.Payload, &players) == nil {
+			others := players[:0]
+			for _, p := range players {
+				if p.ID != c.ID {
+					others = append(others, p)
+				}
+			}
+			onPlayers(others)
+		}
+	case models.Spell:
+		s := models.SpellMsg{}
+		if onSpell != nil && json.Unmarshal(msg.Payload, &s) == nil {
+			onSpell(s)
+		}
+	case models.Chat:
+		m := models.ChatMsg{}
+		if onChat != nil && json.Unmarshal(msg.Payload, &m) == nil {
+			onChat(m)
+		}
+	case models.Death:
+		d := models.DeathMsg{}
+		if onDeath != nil && json.Unmarshal(msg.Payload, &d) == nil {
+			onDeath(d)
+		}
+	case models.UpdateRanking:
+		ranking := []models.RankingPosMsg{}
+		if onRanking != nil && json.Unmarshal(msg.Payload, &ranking) == nil {
+			onRanking(ranking)
+		}
+	case models.System:
+		m := models.SystemMsg{}
+		if onSystem != nil && json.Unmarshal(msg.Payload, &m) == nil {
+			onSystem(m)
+		}
+	case models.Ping:
+		// echoed as is, the server measures the round trip
+		select {
+		case c.out <- models.NewMesg(models.Ping, msg.Payload):
+		default:
+		}
+	}
+}
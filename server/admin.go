@@ -0,0 +1,452 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/locale"
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	PingInterval = 5 * time.Second
+	// KickDelay lets the kick notice reach the client before the connection
+	// is closed
+	KickDelay = time.Second
+)
+
+// Ban is a banned address
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// BanList is the addresses refused by the server, saved to a json file on
+// every change
+type BanList struct {
+	path  string
+	bans  map[string]Ban
+	mutex *sync.RWMutex
+}
+
+var bans = &BanList{bans: map[string]Ban{}, mutex: &sync.RWMutex{}}
+
+// Load reads the bans kept at path, a missing file is an empty list
+func (b *BanList) Load(path string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.path = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &b.bans)
+}
+
+func (b *BanList) Banned(ip string) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	_, ok := b.bans[ip]
+	return ok
+}
+
+func (b *BanList) Add(ip, reason string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bans[ip] = Ban{IP: ip, Reason: reason, Since: time.Now()}
+	return b.save()
+}
+
+func (b *BanList) Remove(ip string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.bans, ip)
+	return b.save()
+}
+
+func (b *BanList) List() []Ban {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	list := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		list = append(list, ban)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Since.Before(list[j].Since)
+	})
+	return list
+}
+
+func (b *BanList) save() error {
+	if b.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(b.bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(b.path, data, 0644)
+}
+
+// IP is the address the client connected from
+func (c *Client) IP() string {
+	if c.conn == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort((*c.conn).RemoteAddr().String())
+	if err != nil {
+		return (*c.conn).RemoteAddr().String()
+	}
+	return host
+}
+
+// RTT is the last round trip time measured with a ping, 0 until then
+func (c *Client) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.rtt))
+}
+
+// pong takes the echo of a ping
+func (c *Client) pong(payload json.RawMessage) {
+	p := models.PingMsg{}
+	if err := json.Unmarshal(payload, &p); err != nil || p.Sent == 0 {
+		return
+	}
+	atomic.StoreInt64(&c.rtt, time.Now().UnixNano()-p.Sent)
+}
+
+func PingMsg() []byte {
+	payload, _ := json.Marshal(models.PingMsg{Sent: time.Now().UnixNano()})
+	return models.NewMesg(models.Ping, payload)
+}
+
+// Kick tells c why and disconnects it
+func (c *Client) Kick(reason string) {
+	text := locale.T(c.locale, "admin.kicked")
+	if reason != "" {
+		text = locale.T(c.locale, "admin.kicked_reason", reason)
+	}
+	payload, _ := json.Marshal(models.SystemMsg{Text: text})
+	c.pushConnected(models.NewMesg(models.System, payload))
+	time.AfterFunc(KickDelay, func() {
+		c.Close(DisconnectKicked)
+	})
+}
+
+// Do runs f in the room loop and waits for it, for anything that touches
+// the state the loop owns from another goroutine. f doesn't run if the
+// room ended.
+func (g *Game) Do(f func()) {
+	done := make(chan struct{})
+	action := func() {
+		f()
+		close(done)
+	}
+	if enqueue(g, g.actions, action) {
+		<-done
+	}
+}
+
+// ClientMsg is a connection as the admin API shows it
+type ClientMsg struct {
+	ID        ksuid.KSUID `json:"id"`
+	IP        string      `json:"ip"`
+	Room      string      `json:"room"`
+	Name      string      `json:"name"`
+	RTT       float64     `json:"rtt_ms"`
+	X         float64     `json:"x"`
+	Y         float64     `json:"y"`
+	Team      models.Team `json:"team"`
+	Spectator bool        `json:"spectator"`
+	SendQueue int         `json:"send_queue"`
+}
+
+// AdminMsg is the body of the admin actions
+type AdminMsg struct {
+	IP      string `json:"ip"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Room    string `json:"room"` // announce to a single room, all if empty
+}
+
+var errNotFound = errors.New("not found")
+
+// Admin is the HTTP API ops use to moderate without a game client. Every
+// request needs the token in an "Authorization: Bearer" header.
+type Admin struct {
+	lobby *Lobby
+	token func() string // read on every request, reloads change it
+}
+
+func NewAdmin(l *Lobby, token func() string) *Admin {
+	return &Admin{lobby: l, token: token}
+}
+
+// ServeAdmin serves the admin API on addr, it refuses to start without a
+// token
+func ServeAdmin(addr string, a *Admin) {
+	if a.token() == "" {
+		slog.Warn("Admin API disabled, it needs a token")
+		return
+	}
+	slog.Info("Serving the admin API", "url", "http://"+addr+"/api/")
+	if err := http.ListenAndServe(addr, a.Handler()); err != nil {
+		slog.Error("Serving the admin API", "err", err)
+	}
+}
+
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/clients", a.get(a.clients))
+	mux.HandleFunc("/api/clients/", a.post(a.kick))
+	mux.HandleFunc("/api/rooms", a.get(func(r *http.Request) (interface{}, error) {
+		return a.lobby.Rooms(), nil
+	}))
+	mux.HandleFunc("/api/rooms/", a.rooms)
+	mux.HandleFunc("/api/bans", a.banList)
+	mux.HandleFunc("/api/announce", a.post(a.announce))
+	return a.auth(mux)
+}
+
+func (a *Admin) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// a reload can empty the token, that locks the API instead of opening it
+		want := a.token()
+		if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "bad token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isBypassToken reports if token is the bypass token, none is when the
+// token isn't set
+func isBypassToken(token string) bool {
+	want := CurrentConfig().BypassToken
+	return want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+func (a *Admin) reply(w http.ResponseWriter, v interface{}, err error) {
+	switch {
+	case err == errNotFound:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case v == nil:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+func (a *Admin) get(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		v, err := h(r)
+		a.reply(w, v, err)
+	}
+}
+
+func (a *Admin) post(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		v, err := h(r)
+		a.reply(w, v, err)
+	}
+}
+
+func decode(r *http.Request) (AdminMsg, error) {
+	m := AdminMsg{}
+	if r.ContentLength == 0 {
+		return m, nil
+	}
+	err := json.NewDecoder(r.Body).Decode(&m)
+	return m, err
+}
+
+// clients handles GET /api/clients
+func (a *Admin) clients(r *http.Request) (interface{}, error) {
+	list := []ClientMsg{}
+	for _, c := range a.lobby.Clients() {
+		m := ClientMsg{
+			ID:        c.ID,
+			IP:        c.IP(),
+			Room:      c.Room(),
+			RTT:       float64(c.RTT()) / float64(time.Millisecond),
+			SendQueue: len(c.send),
+		}
+		c.gameMutex.RLock()
+		g := c.game
+		c.gameMutex.RUnlock()
+		if g != nil {
+			g.Pmutex.RLock()
+			if p, ok := g.Players[c.ID]; ok {
+				m.Name = strings.TrimSpace(p.Name)
+				m.X, m.Y = p.X, p.Y
+				m.Team, m.Spectator = p.Team, p.Spectator
+			}
+			g.Pmutex.RUnlock()
+		}
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID.String() < list[j].ID.String()
+	})
+	return list, nil
+}
+
+// kick handles POST /api/clients/{id}/kick
+func (a *Admin) kick(r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/clients/"), "/")
+	if len(parts) != 2 || parts[1] != "kick" {
+		return nil, errNotFound
+	}
+	id, err := ksuid.Parse(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	m, err := decode(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range a.lobby.Clients() {
+		if c.ID == id {
+			c.log.Info("Admin kick", "reason", m.Reason)
+			c.Kick(m.Reason)
+			return nil, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+// rooms handles GET /api/rooms/{room}/ranking and
+// POST /api/rooms/{room}/ranking/reset
+func (a *Admin) rooms(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/rooms/")
+	reset := strings.HasSuffix(path, "/ranking/reset")
+	name := strings.TrimSuffix(strings.TrimSuffix(path, "/reset"), "/ranking")
+	if !strings.HasSuffix(path, "/ranking") && !reset {
+		a.reply(w, nil, errNotFound)
+		return
+	}
+	g, ok := a.lobby.Room(name)
+	if !ok {
+		a.reply(w, nil, errNotFound)
+		return
+	}
+	switch {
+	case reset && r.Method == http.MethodPost:
+		g.Do(func() {
+			g.Ranking = make(Ranking, 0)
+			g.SystemMessage("admin.ranking_reset")
+		})
+		slog.Info("Admin reset the ranking", "room", g.Name)
+		a.reply(w, nil, nil)
+	case !reset && r.Method == http.MethodGet:
+		var standings []*models.RankingPosMsg
+		g.Do(func() {
+			standings = g.Ranking.Standings()
+		})
+		a.reply(w, standings, nil)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// banList handles GET /api/bans, POST /api/bans to ban an ip and kick
+// whoever is connected from it, and DELETE /api/bans?ip= to lift a ban
+func (a *Admin) banList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.reply(w, bans.List(), nil)
+	case http.MethodPost:
+		m, err := decode(r)
+		if err == nil && net.ParseIP(m.IP) == nil {
+			err = errors.New("bad ip")
+		}
+		if err == nil {
+			err = bans.Add(m.IP, m.Reason)
+		}
+		if err != nil {
+			a.reply(w, nil, err)
+			return
+		}
+		slog.Info("Admin ban", "ip", m.IP, "reason", m.Reason)
+		for _, c := range a.lobby.Clients() {
+			if c.IP() == m.IP {
+				c.Kick(m.Reason)
+			}
+		}
+		a.reply(w, nil, nil)
+	case http.MethodDelete:
+		ip := r.URL.Query().Get("ip")
+		slog.Info("Admin lifted a ban", "ip", ip)
+		a.reply(w, nil, bans.Remove(ip))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// announce handles POST /api/announce
+func (a *Admin) announce(r *http.Request) (interface{}, error) {
+	m, err := decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(m.Message) == "" {
+		return nil, errors.New("empty message")
+	}
+	rooms := []*Game{}
+	if m.Room != "" {
+		g, ok := a.lobby.Room(m.Room)
+		if !ok {
+			return nil, errNotFound
+		}
+		rooms = append(rooms, g)
+	} else {
+		rooms = a.lobby.Games()
+	}
+	for _, g := range rooms {
+		g := g
+		g.Do(func() {
+			g.SystemMessage("admin.announce", m.Message)
+		})
+	}
+	slog.Info("Admin announcement", "message", m.Message)
+	return nil, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestKickAfterDisconnect(t *testing.T) {
+	conn, peer := net.Pipe()
+	lobby := NewLobby()
+	c := testClient(lobby)
+	c.conn = &conn
+	done := make(chan struct{})
+	go func() {
+		c.readPump()
+		close(done)
+	}()
+	peer.Close()
+	<-done
+	// send is closed, the kick must not panic on it
+	c.Kick("bye")
+	if len(lobby.Clients()) != 0 {
+		t.Errorf("%d clients left in the lobby", len(lobby.Clients()))
+	}
+}
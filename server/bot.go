@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/locale"
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+// Bot stats match a player wizard in the client
+const (
+	BotMaxHP      = 347.0
+	BotMaxMP      = 2324.0
+	BotSpeed      = 185.0
+	BotManaRegen  = 120.0 // per second
+	BotPotionHeal = 60.0  // per second while under half health
+	BotSight      = 900.0
+	BotRespawn    = 5 * time.Second
+	// OnTargetRange is how close a bot has to be to cast desca and apoca
+	OnTargetRange = 450.0
+	// MaxBots is the most bots a room hosts, each one costs a client
+	MaxBots = 32
+	// epsilon is a distance too short to have a direction
+	epsilon = 1e-6
+)
+
+// Wizard classes, numbered like WizardType in the client
+const (
+	DarkWizard = iota
+	Monk
+	Shaman
+	Sniper
+	Timewreker
+	Hunter
+)
+
+// botClass is how a bot of one class fights
+type botClass struct {
+	Name  string
+	Skin  int
+	Spell string // the class projectile
+	// Range is the distance the bot keeps from its target
+	Range float64
+}
+
+var botClasses = []botClass{
+	DarkWizard: {Name: "darkwizard", Skin: 3, Spell: "fireball", Range: 250},
+	Monk:       {Name: "monk", Skin: 2, Spell: "healshot", Range: 300},
+	Shaman:     {Name: "shaman", Skin: 0, Spell: "manashot", Range: 300},
+	Sniper:     {Name: "sniper", Skin: 4, Spell: "icesnipe", Range: 550},
+	Timewreker: {Name: "timewreker", Skin: 5, Spell: "rockshot", Range: 250},
+	Hunter:     {Name: "hunter", Skin: 1, Spell: "arrowshot", Range: 500},
+}
+
+// BotDifficulty sets how well bots aim and how fast they react
+type BotDifficulty int
+
+const (
+	BotEasy BotDifficulty = iota
+	BotNormal
+	BotHard
+)
+
+type botSkill struct {
+	aimError float64       // max pixels off the target
+	reaction time.Duration // between decisions
+	castWait time.Duration // between spells
+	lead     bool          // aims where a moving target is going
+	charge   float64       // seconds hunters charge their arrows
+}
+
+var botSkills = map[BotDifficulty]botSkill{
+	BotEasy:   {aimError: 90, reaction: 900 * time.Millisecond, castWait: 2500 * time.Millisecond, charge: .8},
+	BotNormal: {aimError: 40, reaction: 500 * time.Millisecond, castWait: 1400 * time.Millisecond, charge: 1.5},
+	BotHard:   {aimError: 10, reaction: 250 * time.Millisecond, castWait: 800 * time.Millisecond, lead: true, charge: ArrowMaxCharge},
+}
+
+func ParseBotDifficulty(s string) (BotDifficulty, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "easy":
+		return BotEasy, nil
+	case "normal", "":
+		return BotNormal, nil
+	case "hard":
+		return BotHard, nil
+	}
+	return BotNormal, fmt.Errorf("unknown bot difficulty %q", s)
+}
+
+// BotConfig is how many bots of each class a room hosts
+type BotConfig struct {
+	Counts     map[int]int
+	Difficulty BotDifficulty
+}
+
+// ParseBotCounts reads a list like "monk=1,sniper=2", up to MaxBots in all
+func ParseBotCounts(s string) (map[int]int, error) {
+	counts, total := map[int]int{}, 0
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		class := botClassByName(kv[0])
+		if class < 0 || len(kv) != 2 {
+			return nil, fmt.Errorf("bad bot count %q, use class=count", part)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad bot count %q, use class=count", part)
+		}
+		counts[class] += n
+		total += n
+	}
+	if total > MaxBots {
+		return nil, fmt.Errorf("%d bots, a room takes up to %d", total, MaxBots)
+	}
+	return counts, nil
+}
+
+func botClassByName(name string) int {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, c := range botClasses {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Bot is a wizard played by the server. It joins its room as a Client
+// without a connection, so modes, teams and the ranking treat it like
+// anybody else.
+type Bot struct {
+	ID     ksuid.KSUID
+	Name   string
+	Class  int
+	client *Client
+	skill  botSkill
+
+	X, Y, HP, MP float64
+	Dir          string
+	Moving       bool
+	Dead         bool
+	diedAt       time.Time
+
+	target    ksuid.KSUID
+	attacker  ksuid.KSUID // last one that hurt it, fought back first
+	strafe    float64
+	wanderX   float64
+	wanderY   float64
+	nextThink time.Time
+	nextCast  time.Time
+}
+
+func NewBot(name string, class int, difficulty BotDifficulty) *Bot {
+	b := &Bot{
+		ID:     ksuid.New(),
+		Name:   name,
+		Class:  class,
+		skill:  botSkills[difficulty],
+		Dir:    "down",
+		strafe: 1,
+	}
+	b.client = &Client{
+		ID:        b.ID,
+		send:      make(chan []byte, 1024),
+		locale:    locale.Default,
+		gameMutex: &sync.RWMutex{},
+		bot:       b,
+		log:       slog.With("bot", name),
+	}
+	// nobody reads what the room sends to a bot
+	go func() {
+		for range b.client.send {
+		}
+	}()
+	b.respawn()
+	return b
+}
+
+// respawn puts the bot back near the priest, like players revive
+func (b *Bot) respawn() {
+	b.X = 2000 + rand.Float64()*400 - 200
+	b.Y = 2900 + rand.Float64()*400 - 200
+	b.HP, b.MP = BotMaxHP, BotMaxMP
+	b.Dead = false
+	b.target, b.attacker = ksuid.Nil, ksuid.Nil
+	b.wanderX, b.wanderY = b.X, b.Y
+}
+
+// OnMe is the hit box players have in the client
+func (b *Bot) OnMe(x, y float64) bool {
+	return x > b.X-14 && x < b.X+14 && y > b.Y-20 && y < b.Y+30
+}
+
+func (b *Bot) PlayerMsg() models.PlayerMsg {
+	return models.PlayerMsg{
+		ID:     b.ID,
+		Name:   b.Name,
+		Skin:   botClasses[b.Class].Skin,
+		HP:     b.HP,
+		X:      b.X,
+		Y:      b.Y,
+		Dir:    b.Dir,
+		Moving: b.Moving,
+		Dead:   b.Dead,
+		MP:     b.MP,
+		Class:  b.Class,
+		Bot:    true,
+	}
+}
+
+// SpawnBots adds the configured bots to the room
+func (g *Game) SpawnBots() {
+	classes := make([]int, 0, len(g.Bots.Counts))
+	for class := range g.Bots.Counts {
+		classes = append(classes, class)
+	}
+	sort.Ints(classes)
+	for _, class := range classes {
+		for i := 0; i < g.Bots.Counts[class]; i++ {
+			name := fmt.Sprintf("%v %d", strings.Title(botClasses[class].Name), i+1)
+			b := NewBot(name, class, g.Bots.Difficulty)
+			b.client.game = g
+			g.bots[b.ID] = b
+			g.Register(b.client)
+		}
+	}
+}
+
+// UpdateBots moves the spells and the bots, then stores the bots state
+// like a client update would
+func (g *Game) UpdateBots(dt float64) {
+	g.UpdateSpells(dt)
+	now := time.Now()
+	for _, b := range g.bots {
+		if b.Dead {
+			respawn := g.Match.State != models.MatchRunning || g.Mode.Respawn()
+			if respawn && now.Sub(b.diedAt) > BotRespawn {
+				b.respawn()
+			}
+		} else {
+			b.update(g, dt, now)
+		}
+		payload, _ := json.Marshal(b.PlayerMsg())
+		g.clientUpdate(BroadcastEvent{Client: b.client, Event: models.UpdateServer, Payload: payload})
+	}
+}
+
+// ReviveBots brings every bot back, for rounds where nobody respawns
+func (g *Game) ReviveBots() {
+	for _, b := range g.bots {
+		b.respawn()
+	}
+}
+
+func (b *Bot) update(g *Game, dt float64, now time.Time) {
+	b.MP = math.Min(BotMaxMP, b.MP+BotManaRegen*dt)
+	if b.HP < BotMaxHP/2 {
+		b.HP = math.Min(BotMaxHP, b.HP+BotPotionHeal*dt)
+	}
+	if now.After(b.nextThink) {
+		b.think(g)
+		b.nextThink = now.Add(b.skill.reaction)
+		if now.After(b.nextCast) && b.cast(g) {
+			b.nextCast = now.Add(b.skill.castWait)
+		}
+	}
+	b.move(g, dt)
+}
+
+// think picks the target, whoever hurt the bot last or the closest enemy
+func (b *Bot) think(g *Game) {
+	if t := g.Players[b.attacker]; b.validTarget(g, t) {
+		b.target = b.attacker
+		return
+	}
+	b.attacker = ksuid.Nil
+	if t := g.Players[b.target]; b.validTarget(g, t) {
+		return
+	}
+	b.target = ksuid.Nil
+	closest := BotSight
+	for id, p := range g.Players {
+		if d := math.Hypot(p.X-b.X, p.Y-b.Y); d < closest && b.validTarget(g, p) {
+			b.target, closest = id, d
+		}
+	}
+	if rand.Float64() < .2 {
+		b.strafe = -b.strafe
+	}
+}
+
+// validTarget reports if p is an enemy in sight the bot can hurt
+func (b *Bot) validTarget(g *Game, p *models.PlayerMsg) bool {
+	if p == nil || p.ID == b.ID || p.Dead || p.Spectator || p.Duel != ksuid.Nil {
+		return false
+	}
+	if b.client.team != models.NoTeam && p.Team == b.client.team {
+		return false
+	}
+	return math.Hypot(p.X-b.X, p.Y-b.Y) < BotSight
+}
+
+// cast throws the best spell it can at its target, monks heal wounded
+// allies first. It reports if a spell was cast.
+func (b *Bot) cast(g *Game) bool {
+	class := botClasses[b.Class]
+	if b.Class == Monk {
+		if ally := b.woundedAlly(g); ally != nil {
+			return b.castAt(g, class.Spell, ally)
+		}
+	}
+	t := g.Players[b.target]
+	if t == nil {
+		return false
+	}
+	d := math.Hypot(t.X-b.X, t.Y-b.Y)
+	spell := spellBook[class.Spell]
+	switch {
+	case d < OnTargetRange && b.MP >= spellBook["apoca"].ManaCost && rand.Float64() < .3:
+		return b.castAt(g, "apoca", t)
+	case !spell.Support && d < spell.Speed*math.Sqrt2*spell.Life && b.MP >= spell.ManaCost:
+		return b.castAt(g, class.Spell, t)
+	case d < OnTargetRange && b.MP >= spellBook["desca"].ManaCost:
+		return b.castAt(g, "desca", t)
+	}
+	return false
+}
+
+// woundedAlly returns the teammate in range with less health
+func (b *Bot) woundedAlly(g *Game) *models.PlayerMsg {
+	if b.client.team == models.NoTeam {
+		return nil
+	}
+	var ally *models.PlayerMsg
+	for _, p := range g.Players {
+		if p.Team != b.client.team || p.Dead || p.Spectator || p.HP > BotMaxHP*.6 {
+			continue
+		}
+		if math.Hypot(p.X-b.X, p.Y-b.Y) < 300 && (ally == nil || p.HP < ally.HP) {
+			ally = p
+		}
+	}
+	return ally
+}
+
+// castAt sends the spell to every client as if the bot had cast it
+func (b *Bot) castAt(g *Game, name string, t *models.PlayerMsg) bool {
+	info := spellBook[name]
+	if b.MP < info.ManaCost {
+		return false
+	}
+	s := models.SpellMsg{
+		ID:        b.ID,
+		Name:      b.Name,
+		SpellName: name,
+		SpellType: info.Type,
+		X:         t.X,
+		Y:         t.Y,
+	}
+	if info.Type == "on-target" {
+		s.TargetID = t.ID
+	} else {
+		if b.skill.lead && t.Moving {
+			speed := info.Speed * math.Sqrt2
+			eta := math.Hypot(t.X-b.X, t.Y-b.Y) / speed
+			dx, dy := dirVec(t.Dir)
+			s.X += dx * BotSpeed * eta
+			s.Y += dy * BotSpeed * eta
+		}
+		s.X += (rand.Float64()*2 - 1) * b.skill.aimError
+		s.Y += (rand.Float64()*2 - 1) * b.skill.aimError
+	}
+	if info.Type == "casted-projectile" {
+		s.ChargeTime = b.skill.charge
+	}
+	b.MP -= info.ManaCost
+	payload, _ := json.Marshal(s)
+	g.CastSpell(s)
+	g.broadcastEvent(BroadcastEvent{Client: b.client, Event: models.Spell, Payload: payload})
+	return true
+}
+
+// move keeps the class distance from the target: closer, away or
+// strafing around it. Without a target the bot wanders.
+func (b *Bot) move(g *Game, dt float64) {
+	var dx, dy float64
+	if t := g.Players[b.target]; t != nil && b.target != ksuid.Nil {
+		dx, dy = t.X-b.X, t.Y-b.Y
+		d := math.Hypot(dx, dy)
+		if d < epsilon {
+			b.Moving = false
+			return
+		}
+		dx, dy = dx/d, dy/d
+		r := botClasses[b.Class].Range
+		switch {
+		case d > r+60:
+		case d < r-60:
+			dx, dy = -dx, -dy
+		default:
+			dx, dy = -dy*b.strafe, dx*b.strafe
+		}
+	} else {
+		dx, dy = b.wanderX-b.X, b.wanderY-b.Y
+		d := math.Hypot(dx, dy)
+		if d < 20 {
+			b.wanderX = clamp(b.X+rand.Float64()*800-400, 100, MapSize-100)
+			b.wanderY = clamp(b.Y+rand.Float64()*800-400, 100, MapSize-100)
+			b.Moving = false
+			return
+		}
+		dx, dy = dx/d, dy/d
+	}
+	b.X = clamp(b.X+dx*BotSpeed*dt, 60, MapSize-60)
+	b.Y = clamp(b.Y+dy*BotSpeed*dt, 60, MapSize-60)
+	b.Moving = true
+	switch {
+	case math.Abs(dx) > math.Abs(dy) && dx > 0:
+		b.Dir = "right"
+	case math.Abs(dx) > math.Abs(dy):
+		b.Dir = "left"
+	case dy > 0:
+		b.Dir = "up"
+	default:
+		b.Dir = "down"
+	}
+}
+
+func dirVec(dir string) (float64, float64) {
+	switch dir {
+	case "up":
+		return 0, 1
+	case "down":
+		return 0, -1
+	case "left":
+		return -1, 0
+	case "right":
+		return 1, 0
+	}
+	return 0, 0
+}
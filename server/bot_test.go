@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBotCounts(t *testing.T) {
+	monk, sniper := botClassByName("monk"), botClassByName("sniper")
+	tests := []struct {
+		in   string
+		want map[int]int
+		err  bool
+	}{
+		{"", map[int]int{}, false},
+		{"monk=1", map[int]int{monk: 1}, false},
+		{" Monk = 1 , sniper=2 ,", map[int]int{monk: 1, sniper: 2}, false},
+		{"monk=1,monk=2", map[int]int{monk: 3}, false},
+		{"monk=0", map[int]int{monk: 0}, false},
+		{"monk", nil, true},
+		{"monk=-1", nil, true},
+		{"monk=one", nil, true},
+		{"golem=1", nil, true},
+		{"monk=32", map[int]int{monk: MaxBots}, false},
+		{"monk=20,sniper=13", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseBotCounts(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("ParseBotCounts(%q) error = %v", tt.in, err)
+			}
+			if !tt.err && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBotCounts(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
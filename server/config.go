@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/logging"
+)
+
+const (
+	DefaultPort     = 33333
+	DefaultTickRate = 20
+	MaxTickRate     = 120
+	// EnvPrefix names the variables that override the config file, the
+	// -admin-token flag is GOAO_ADMIN_TOKEN
+	EnvPrefix = "GOAO_"
+)
+
+// Config is what the server runs with. It is read from a json file, then
+// the GOAO_* environment variables and then the flags, each one overriding
+// the previous.
+type Config struct {
+	// Bind is the address to listen on, empty for every interface
+	Bind string `json:"bind"`
+	Port int    `json:"port"`
+	// Name is how the server is listed, Discovery answers LAN probes
+	Name       string `json:"name"`
+	Discovery  bool   `json:"discovery"`
+	MaxPlayers int    `json:"max_players"` // per room, 0 for no limit
+	// MaxQueue is how many clients wait for a place in a full room,
+	// MaxConnections how many the server takes in total
+	MaxQueue       int `json:"max_queue"`
+	MaxConnections int `json:"max_connections"`
+	// BypassToken lets the clients sending it in full rooms, it is not the
+	// admin token as it travels on the game connection
+	BypassToken string `json:"bypass_token"`
+	// Master is the url of the master server to be listed in, PublicAddr
+	// the host:port players connect to when it isn't the address the
+	// heartbeats come from
+	Master       string `json:"master"`
+	MasterSecret string `json:"master_secret"`
+	PublicAddr   string `json:"public_addr"`
+	// TickRate is how many times a second bots and server spells update
+	TickRate        int          `json:"tick_rate"`
+	RankingInterval Duration     `json:"ranking_interval"`
+	PingInterval    Duration     `json:"ping_interval"`
+	MOTD            string       `json:"motd"`
+	Rooms           []RoomConfig `json:"rooms"`
+	DataDir         string       `json:"data_dir"`
+	ReplayDir       string       `json:"replay_dir"`
+	Metrics         string       `json:"metrics"`
+	Pprof           bool         `json:"pprof"`
+	Admin           string       `json:"admin"`
+	AdminToken      string       `json:"admin_token"`
+	Web             string       `json:"web"`
+	LogLevel        string       `json:"log_level"`
+	LogFormat       string       `json:"log_format"`
+	// ShutdownCountdown is how long players are warned before a SIGINT or
+	// SIGTERM disconnects them, Restore brings back the rooms saved then
+	ShutdownCountdown Duration `json:"shutdown_countdown"`
+	Restore           bool     `json:"restore"`
+}
+
+// RoomConfig is a room started with the server
+type RoomConfig struct {
+	Name          string `json:"name"`
+	Mode          string `json:"mode"`
+	Bots          string `json:"bots"` // by class, like monk=1,sniper=2
+	BotDifficulty string `json:"bot_difficulty"`
+	MaxPlayers    int    `json:"max_players"` // 0 for the server max_players
+}
+
+// Parse reads the mode and bots of the room
+func (r RoomConfig) Parse() (GameMode, BotConfig, error) {
+	bots := BotConfig{}
+	name := r.Mode
+	if name == "" {
+		name = "ffa"
+	}
+	mode, err := ModeByName(name)
+	if err != nil {
+		return nil, bots, err
+	}
+	if bots.Counts, err = ParseBotCounts(r.Bots); err != nil {
+		return nil, bots, err
+	}
+	if bots.Difficulty, err = ParseBotDifficulty(r.BotDifficulty); err != nil {
+		return nil, bots, err
+	}
+	return mode, bots, nil
+}
+
+// Duration is a time.Duration written like "5s" in json
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Port:            DefaultPort,
+		Name:            defaultName(),
+		Discovery:       true,
+		MaxQueue:        20,
+		MaxConnections:  1000,
+		TickRate:        DefaultTickRate,
+		RankingInterval: Duration(time.Second),
+		PingInterval:    Duration(PingInterval),
+		Rooms:           []RoomConfig{{Name: "main", Mode: "ffa"}},
+		DataDir:         "./data",
+		Admin:           "127.0.0.1:8081",
+		LogLevel:        "info",
+		LogFormat:       "text",
+
+		ShutdownCountdown: Duration(10 * time.Second),
+		Restore:           true,
+	}
+}
+
+// defaultName lists the server by the name of its machine
+func defaultName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "Go AO"
+	}
+	return host
+}
+
+var (
+	config      = DefaultConfig()
+	configMutex = &sync.RWMutex{}
+)
+
+// CurrentConfig is the config running, it changes on reloads so it is
+// read again every time instead of kept
+func CurrentConfig() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config
+}
+
+func SetConfig(c *Config) {
+	configMutex.Lock()
+	config = c
+	configMutex.Unlock()
+}
+
+// LoadConfig builds the config from the defaults, the file given with
+// -config or GOAO_CONFIG, the environment and the flags in args
+func LoadConfig(args []string) (*Config, error) {
+	c := DefaultConfig()
+	var mode, bots, difficulty string
+	path := os.Getenv(EnvPrefix + "CONFIG")
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&path, "config", path, "json config file, GOAO_* variables and flags override it")
+	fs.StringVar(&c.Bind, "bind", c.Bind, "address to listen on, empty for every interface, 0.0.0.0 for IPv4 only or :: for IPv6")
+	fs.IntVar(&c.Port, "port", c.Port, "port to listen on")
+	fs.StringVar(&c.Name, "name", c.Name, "name the server is listed with")
+	fs.BoolVar(&c.Discovery, "discovery", c.Discovery, "answer LAN discovery probes over UDP on the game port")
+	fs.StringVar(&c.Master, "master", c.Master, "url of the master server to be listed in, empty to not be listed")
+	fs.StringVar(&c.MasterSecret, "master-secret", c.MasterSecret, "secret shared with the master server")
+	fs.StringVar(&c.PublicAddr, "public-addr", c.PublicAddr, "host:port players connect to, empty for the address the master sees")
+	fs.IntVar(&c.MaxPlayers, "max-players", c.MaxPlayers, "players per room, 0 for no limit")
+	fs.IntVar(&c.MaxQueue, "max-queue", c.MaxQueue, "clients waiting for a place in a full room, 0 for no limit")
+	fs.IntVar(&c.MaxConnections, "max-connections", c.MaxConnections, "connections the server takes, 0 for no limit")
+	fs.StringVar(&c.BypassToken, "bypass-token", c.BypassToken, "token of the clients let in full rooms, none is without one")
+	fs.IntVar(&c.TickRate, "tick-rate", c.TickRate, "updates a second of bots and server spells")
+	fs.DurationVar((*time.Duration)(&c.RankingInterval), "ranking-interval", time.Duration(c.RankingInterval), "how often the ranking is sent")
+	fs.DurationVar((*time.Duration)(&c.PingInterval), "ping-interval", time.Duration(c.PingInterval), "how often clients are pinged")
+	fs.StringVar(&c.MOTD, "motd", c.MOTD, "message shown to players joining a room")
+	fs.StringVar(&mode, "mode", "", "game mode of the first room: ffa, tdm, ctf, br, koth or tkoth")
+	fs.StringVar(&bots, "bots", "", "bots in the first room by class, like monk=1,sniper=2")
+	fs.StringVar(&difficulty, "bot-difficulty", "", "how well bots of the first room play: easy, normal or hard")
+	fs.StringVar(&c.DataDir, "data", c.DataDir, "directory where duel records, bans and stats are kept")
+	fs.StringVar(&c.ReplayDir, "replays", c.ReplayDir, "directory where matches are recorded, empty to not record them")
+	fs.StringVar(&c.Metrics, "metrics", c.Metrics, "address of the metrics endpoint, like :9100, empty to disable it")
+	fs.BoolVar(&c.Pprof, "pprof", c.Pprof, "serve pprof under /debug/pprof/ on the metrics address")
+	fs.StringVar(&c.Admin, "admin", c.Admin, "address of the admin API, empty to disable it")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "token the admin API asks for, it is disabled without one")
+	fs.StringVar(&c.Web, "web", c.Web, "address of the leaderboard page, like :8080, empty to disable it")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "least important logs shown: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "format of the logs: text or json")
+	fs.DurationVar((*time.Duration)(&c.ShutdownCountdown), "shutdown-countdown", time.Duration(c.ShutdownCountdown), "how long players are warned before the server shuts down")
+	fs.BoolVar(&c.Restore, "restore", c.Restore, "restore the rooms and matches saved on the last shutdown")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	if path != "" {
+		if err := c.load(path); err != nil {
+			return nil, err
+		}
+	}
+	// the file replaced what the flags had set, the environment and the
+	// flags are applied again on top of it
+	applied := map[string]bool{}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || err != nil {
+			return
+		}
+		value, ok := flags[f.Name]
+		from := "-" + f.Name
+		if !ok {
+			from = envName(f.Name)
+			value, ok = os.LookupEnv(from)
+		}
+		if !ok {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("%v: %v", from, e)
+		}
+		applied[f.Name] = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Rooms) > 0 {
+		if applied["mode"] {
+			c.Rooms[0].Mode = mode
+		}
+		if applied["bots"] {
+			c.Rooms[0].Bots = bots
+		}
+		if applied["bot-difficulty"] {
+			c.Rooms[0].BotDifficulty = difficulty
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
+
+// envName is the variable overriding a flag, -max-players is
+// GOAO_MAX_PLAYERS
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Validate returns every problem of the config at once
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if c.Bind != "" && net.ParseIP(c.Bind) == nil {
+		bad("bind %q is not an IP address", c.Bind)
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		bad("port %d out of range", c.Port)
+	}
+	if strings.TrimSpace(c.Name) == "" {
+		bad("name can't be empty")
+	}
+	if c.Master != "" {
+		if u, err := url.Parse(c.Master); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			bad("master %q is not an http url", c.Master)
+		}
+		if c.MasterSecret == "" {
+			bad("master needs a master_secret")
+		}
+	}
+	if _, _, err := net.SplitHostPort(c.PublicAddr); c.PublicAddr != "" && err != nil {
+		bad("public_addr: %v", err)
+	}
+	if c.MaxPlayers < 0 {
+		bad("max_players can't be negative")
+	}
+	if c.MaxQueue < 0 {
+		bad("max_queue can't be negative")
+	}
+	if c.MaxConnections < 0 {
+		bad("max_connections can't be negative")
+	}
+	if c.BypassToken != "" && c.BypassToken == c.AdminToken {
+		bad("bypass_token can't be the admin_token")
+	}
+	if c.TickRate < 1 || c.TickRate > MaxTickRate {
+		bad("tick_rate has to be between 1 and %d", MaxTickRate)
+	}
+	if c.RankingInterval <= 0 {
+		bad("ranking_interval has to be positive")
+	}
+	if c.PingInterval <= 0 {
+		bad("ping_interval has to be positive")
+	}
+	if c.ShutdownCountdown < 0 {
+		bad("shutdown_countdown can't be negative")
+	}
+	for name, addr := range map[string]string{"metrics": c.Metrics, "admin": c.Admin, "web": c.Web} {
+		if _, _, err := net.SplitHostPort(addr); addr != "" && err != nil {
+			bad("%v: %v", name, err)
+		}
+	}
+	if _, err := logging.New(logging.Options{Level: c.LogLevel, Format: c.LogFormat, Output: io.Discard}); err != nil {
+		bad("%v", err)
+	}
+	if len(c.Rooms) == 0 {
+		bad("there has to be at least one room")
+	}
+	if len(c.Rooms) > MaxRooms {
+		bad("there can't be more than %d rooms", MaxRooms)
+	}
+	names := map[string]bool{}
+	for i, r := range c.Rooms {
+		if !validRoomName(r.Name) {
+			bad("room %d: bad name %q", i+1, r.Name)
+		}
+		if names[r.Name] {
+			bad("room %q defined twice", r.Name)
+		}
+		names[r.Name] = true
+		if _, _, err := r.Parse(); err != nil {
+			bad("room %q: %v", r.Name, err)
+		}
+		if r.MaxPlayers < 0 {
+			bad("room %q: max_players can't be negative", r.Name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Addr is the address to listen on. An IPv4 or IPv6 bind address listens
+// on that family only, an empty one on both.
+func (c *Config) Addr() (network, addr string) {
+	network = "tcp"
+	if ip := net.ParseIP(c.Bind); ip != nil {
+		network = "tcp6"
+		if ip.To4() != nil {
+			network = "tcp4"
+		}
+	}
+	return network, net.JoinHostPort(c.Bind, strconv.Itoa(c.Port))
+}
+
+// Tick is the time between bot and spell updates
+func (c *Config) Tick() time.Duration {
+	return time.Second / time.Duration(c.TickRate)
+}
+
+// restartOnly lists the settings that changed in next but only take effect
+// on a restart
+func (c *Config) restartOnly(next *Config) []string {
+	changed := []string{}
+	check := func(name string, a, b interface{}) {
+		if a != b {
+			changed = append(changed, name)
+		}
+	}
+	check("bind", c.Bind, next.Bind)
+	check("port", c.Port, next.Port)
+	check("discovery", c.Discovery, next.Discovery)
+	check("tick_rate", c.TickRate, next.TickRate)
+	check("ranking_interval", c.RankingInterval, next.RankingInterval)
+	check("ping_interval", c.PingInterval, next.PingInterval)
+	check("data_dir", c.DataDir, next.DataDir)
+	check("replay_dir", c.ReplayDir, next.ReplayDir)
+	check("metrics", c.Metrics, next.Metrics)
+	check("pprof", c.Pprof, next.Pprof)
+	check("admin", c.Admin, next.Admin)
+	check("web", c.Web, next.Web)
+	check("log_format", c.LogFormat, next.LogFormat)
+	check("restore", c.Restore, next.Restore)
+	return changed
+}
+
+// Reload reads the config again with the same args and applies what can
+// change while running: the name, master server, motd, player limits, log
+// level, admin and bypass tokens, shutdown countdown and new rooms. Other
+// changes are logged and wait for a restart.
+func Reload(args []string, lobby *Lobby) error {
+	next, err := LoadConfig(args)
+	if err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return err
+	}
+	running := *CurrentConfig()
+	for _, name := range running.restartOnly(next) {
+		slog.Warn("Config change needs a restart", "setting", name)
+	}
+	running.MOTD = next.MOTD
+	running.Name = next.Name
+	running.Master = next.Master
+	running.MasterSecret = next.MasterSecret
+	running.PublicAddr = next.PublicAddr
+	running.MaxPlayers = next.MaxPlayers
+	running.MaxQueue = next.MaxQueue
+	running.MaxConnections = next.MaxConnections
+	running.BypassToken = next.BypassToken
+	running.LogLevel = next.LogLevel
+	running.AdminToken = next.AdminToken
+	running.ShutdownCountdown = next.ShutdownCountdown
+	running.Rooms = next.Rooms
+	if err := logging.SetLevel(running.LogLevel); err != nil {
+		return err
+	}
+	SetConfig(&running)
+	return lobby.ApplyRooms(running.Rooms)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	file := `{"max_players": 4, "motd": "from the file", "rooms": [{"name": "main", "mode": "tdm"}]}`
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		env        map[string]string
+		args       []string
+		maxPlayers int
+		motd       string
+		mode       string
+	}{
+		{"defaults", nil, nil, 0, "", "ffa"},
+		{"file", nil, []string{"-config", path}, 4, "from the file", "tdm"},
+		{"file from the environment", map[string]string{"GOAO_CONFIG": path}, nil, 4, "from the file", "tdm"},
+		{"environment over file", map[string]string{"GOAO_MAX_PLAYERS": "6"}, []string{"-config", path}, 6, "from the file", "tdm"},
+		{"flags over environment", map[string]string{"GOAO_MAX_PLAYERS": "6"}, []string{"-config", path, "-max-players", "8"}, 8, "from the file", "tdm"},
+		{"flags over file", nil, []string{"-config", path, "-motd", "from a flag", "-mode", "ctf"}, 4, "from a flag", "ctf"},
+		{"environment over file room", map[string]string{"GOAO_MODE": "koth"}, []string{"-config", path}, 4, "from the file", "koth"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, err := LoadConfig(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.MaxPlayers != tt.maxPlayers || c.MOTD != tt.motd || c.Rooms[0].Mode != tt.mode {
+				t.Errorf("max_players %d, motd %q, mode %q, want %d, %q, %q", c.MaxPlayers, c.MOTD, c.Rooms[0].Mode, tt.maxPlayers, tt.motd, tt.mode)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.json")
+	ioutil.WriteFile(bad, []byte(`{"port": "high"}`), 0644)
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+	}{
+		{"missing file", nil, []string{"-config", filepath.Join(dir, "none.json")}},
+		{"bad file", nil, []string{"-config", bad}},
+		{"bad variable", map[string]string{"GOAO_PORT": "abc"}, nil},
+		{"unknown flag", nil, []string{"-nope"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := LoadConfig(tt.args); err == nil {
+				t.Error("LoadConfig() took it")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		err    string // in the error, "" for none
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"port", func(c *Config) { c.Port = 70000 }, "port 70000 out of range"},
+		{"bind", func(c *Config) { c.Bind = "localhost" }, "bind"},
+		{"empty name", func(c *Config) { c.Name = " " }, "name can't be empty"},
+		{"master without secret", func(c *Config) { c.Master = "http://master" }, "master needs a master_secret"},
+		{"master url", func(c *Config) { c.Master, c.MasterSecret = "master", "s" }, "is not an http url"},
+		{"negative queue", func(c *Config) { c.MaxQueue = -1 }, "max_queue can't be negative"},
+		{"bypass is the admin token", func(c *Config) { c.AdminToken, c.BypassToken = "t", "t" }, "bypass_token can't be the admin_token"},
+		{"tick rate", func(c *Config) { c.TickRate = MaxTickRate + 1 }, "tick_rate"},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, "loud"},
+		{"no rooms", func(c *Config) { c.Rooms = nil }, "at least one room"},
+		{"room twice", func(c *Config) { c.Rooms = append(c.Rooms, c.Rooms[0]) }, `room "main" defined twice`},
+		{"slash in a room name", func(c *Config) { c.Rooms[0].Name = "a/b" }, "bad name"},
+		{"padded room name", func(c *Config) { c.Rooms[0].Name = " main" }, "bad name"},
+		{"room mode", func(c *Config) { c.Rooms[0].Mode = "golf" }, `room "main"`},
+		{"too many bots", func(c *Config) { c.Rooms[0].Bots = "monk=33" }, "33 bots"},
+		{"every problem", func(c *Config) { c.Port, c.TickRate = 0, 0 }, "tick_rate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			tt.change(c)
+			err := c.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate() = %v, want %q in it", err, tt.err)
+			}
+		})
+	}
+}
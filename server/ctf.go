@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	FlagTouchRadius = 40.0
+	FlagReturnAfter = 30 * time.Second
+)
+
+type ctfFlag struct {
+	models.FlagMsg
+	dropped time.Time
+}
+
+func (f *ctfFlag) reset() {
+	f.State = models.FlagAtBase
+	f.Carrier = ksuid.Nil
+	f.X, f.Y = f.BaseX, f.BaseY
+}
+
+func (f *ctfFlag) drop(x, y float64) {
+	f.State = models.FlagDropped
+	f.Carrier = ksuid.Nil
+	f.X, f.Y = x, y
+	f.dropped = time.Now()
+}
+
+// CaptureTheFlag is played in the forest arena: the red base sits at the
+// path entrance and the blue one at the back of the arena
+type CaptureTheFlag struct {
+	baseMode
+	CaptureLimit int
+	Duration     time.Duration
+	flags        map[models.Team]*ctfFlag
+}
+
+func NewCaptureTheFlag() *CaptureTheFlag {
+	m := &CaptureTheFlag{
+		CaptureLimit: 3,
+		Duration:     15 * time.Minute,
+		flags: map[models.Team]*ctfFlag{
+			models.RedTeam:  {FlagMsg: models.FlagMsg{Team: models.RedTeam, BaseX: 2000, BaseY: 400}},
+			models.BlueTeam: {FlagMsg: models.FlagMsg{Team: models.BlueTeam, BaseX: 2000, BaseY: 3700}},
+		},
+	}
+	for _, f := range m.flags {
+		f.reset()
+	}
+	return m
+}
+
+func (m *CaptureTheFlag) Name() string             { return "ctf" }
+func (m *CaptureTheFlag) Teams() bool              { return true }
+func (m *CaptureTheFlag) TimeLimit() time.Duration { return m.Duration }
+
+func (m *CaptureTheFlag) Start(g *Game) {
+	for _, f := range m.flags {
+		f.reset()
+		f.Score = 0
+	}
+	g.Broadcast(m.FlagsMsg())
+}
+
+func (m *CaptureTheFlag) OnDeath(g *Game, d models.DeathMsg) {
+	m.dropCarried(g, d.Killed)
+}
+
+func (m *CaptureTheFlag) OnLeave(g *Game, c *Client) {
+	m.dropCarried(g, c.ID)
+}
+
+// dropCarried drops the flag id carries, if any, where the carrier was
+func (m *CaptureTheFlag) dropCarried(g *Game, id ksuid.KSUID) {
+	for _, f := range m.flags {
+		if f.State == models.FlagCarried && f.Carrier == id {
+			name := ""
+			if p, ok := g.Players[id]; ok {
+				name = strings.TrimSpace(p.Name)
+			}
+			f.drop(f.X, f.Y)
+			g.SystemMessage("system.flag_dropped", name)
+			g.Broadcast(m.FlagsMsg())
+		}
+	}
+}
+
+func (m *CaptureTheFlag) OnPlayerUpdate(g *Game, c *Client, p *models.PlayerMsg) {
+	if p.Dead {
+		m.dropCarried(g, c.ID)
+		return
+	}
+	changed := false
+	name := strings.TrimSpace(p.Name)
+	for _, f := range m.flags {
+		switch {
+		case f.State == models.FlagCarried && f.Carrier == c.ID:
+			f.X, f.Y = p.X, p.Y
+			own := m.flags[c.team]
+			if own.State == models.FlagAtBase && touching(own.BaseX, own.BaseY, p.X, p.Y) {
+				own.Score++
+				f.reset()
+				g.SystemMessage("system.flag_captured", name)
+				changed = true
+			}
+		case f.State != models.FlagCarried && f.Team != c.team && touching(f.X, f.Y, p.X, p.Y):
+			f.State = models.FlagCarried
+			f.Carrier = c.ID
+			g.SystemMessage("system.flag_taken", name)
+			changed = true
+		case f.State == models.FlagDropped && f.Team == c.team && touching(f.X, f.Y, p.X, p.Y):
+			f.reset()
+			g.SystemMessage("system.flag_returned", name)
+			changed = true
+		}
+	}
+	if changed {
+		g.Broadcast(m.FlagsMsg())
+	}
+}
+
+func (m *CaptureTheFlag) Tick(g *Game) {
+	for _, f := range m.flags {
+		if f.State == models.FlagDropped && time.Since(f.dropped) > FlagReturnAfter {
+			f.reset()
+		}
+	}
+	g.Broadcast(m.FlagsMsg())
+}
+
+func (m *CaptureTheFlag) Over(g *Game) bool {
+	for _, f := range m.flags {
+		if f.Score >= m.CaptureLimit {
+			return true
+		}
+	}
+	return false
+}
+
+// Scores are the captures, the flags go back to their bases on a restore
+func (m *CaptureTheFlag) Scores() map[models.Team]int {
+	scores := map[models.Team]int{}
+	for t, f := range m.flags {
+		scores[t] = f.Score
+	}
+	return scores
+}
+
+func (m *CaptureTheFlag) RestoreScores(scores map[models.Team]int) {
+	for t, score := range scores {
+		if f, ok := m.flags[t]; ok {
+			f.Score = score
+		}
+	}
+}
+
+func (m *CaptureTheFlag) Result(g *Game) *models.MatchResultMsg {
+	r := &models.MatchResultMsg{
+		Standings:  g.Ranking.Standings(),
+		TeamScores: map[models.Team]int{},
+	}
+	for t, f := range m.flags {
+		r.TeamScores[t] = f.Score
+	}
+	r.WinnerTeam = leadingTeam(r.TeamScores)
+	return r
+}
+
+func (m *CaptureTheFlag) FlagsMsg() []byte {
+	flags := []models.FlagMsg{m.flags[models.RedTeam].FlagMsg, m.flags[models.BlueTeam].FlagMsg}
+	payload, _ := json.Marshal(flags)
+	return models.NewMesg(models.Flags, payload)
+}
+
+func touching(x1, y1, x2, y2 float64) bool {
+	return models.InsideRadius(x1, y1, x2, y2, FlagTouchRadius)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/juanefec/go-pixel-ao/discovery"
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+// ServeDiscovery answers LAN discovery probes over UDP on the game port
+func ServeDiscovery(cfg *Config, lobby *Lobby) {
+	network, addr := cfg.Addr()
+	conn, err := net.ListenPacket(strings.Replace(network, "tcp", "udp", 1), addr)
+	if err != nil {
+		slog.Error("Discovery disabled", "addr", addr, "err", err)
+		return
+	}
+	slog.Info("Answering discovery probes", "addr", conn.LocalAddr().String())
+	if err := discovery.Serve(conn, lobby.ServerInfo); err != nil {
+		slog.Error("Answering discovery probes", "err", err)
+	}
+}
+
+// ServerInfo describes the server to the ones looking for it
+func (l *Lobby) ServerInfo() models.ServerInfoMsg {
+	cfg := CurrentConfig()
+	info := models.ServerInfoMsg{
+		Name:       cfg.Name,
+		Rooms:      len(l.Games()),
+		Players:    len(l.Clients()),
+		MaxPlayers: cfg.MaxPlayers,
+		Version:    models.ProtocolVersion,
+		Port:       cfg.Port,
+	}
+	if g, ok := l.Room(cfg.Rooms[0].Name); ok {
+		info.Mode = g.Mode.Name()
+	}
+	return info
+}
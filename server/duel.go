@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	DuelChallengeTimeout = 30 * time.Second
+	DuelCountdown        = 5 * time.Second
+	DuelTimeLimit        = 3 * time.Minute
+)
+
+// Duel spots, at the back of the arena away from the resurrection priest
+var duelSpots = [2]struct{ X, Y float64 }{
+	{X: 3300, Y: 3600},
+	{X: 3600, Y: 3600},
+}
+
+type duel struct {
+	ID    ksuid.KSUID
+	State models.DuelState
+	Ends  time.Time
+	// players[i] stands on duelSpots[i]
+	players [2]*Client
+	names   [2]string
+}
+
+func (d *duel) opponent(c *Client) int {
+	if d.players[0] == c {
+		return 1
+	}
+	return 0
+}
+
+type challenge struct {
+	from    *Client
+	expires time.Time
+}
+
+// Duels keeps the pending challenges and running duels of a room
+type Duels struct {
+	challenges map[*Client]challenge // by challenged client
+	running    map[*Client]*duel
+}
+
+func NewDuels() *Duels {
+	return &Duels{
+		challenges: make(map[*Client]challenge),
+		running:    make(map[*Client]*duel),
+	}
+}
+
+// Of returns the duel c is in, nil if none
+func (ds *Duels) Of(c *Client) *duel {
+	return ds.running[c]
+}
+
+// DuelRecord is the persisted result of all the duels of a player name
+type DuelRecord struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+}
+
+// DuelRecords are shared by every room and saved to a json file after
+// every duel
+type DuelRecords struct {
+	path    string
+	records map[string]*DuelRecord
+	mutex   *sync.Mutex
+}
+
+var duelRecords = &DuelRecords{records: map[string]*DuelRecord{}, mutex: &sync.Mutex{}}
+
+// Load reads the records kept at path, a missing file is an empty record
+func (r *DuelRecords) Load(path string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.path = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &r.records)
+}
+
+// Add counts a duel and saves the records, it returns both players records
+func (r *DuelRecords) Add(winner, loser string) (DuelRecord, DuelRecord) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	w, l := r.get(winner), r.get(loser)
+	w.Wins++
+	l.Losses++
+	if err := r.save(); err != nil {
+		slog.Error("Saving duel records", "err", err)
+	}
+	return *w, *l
+}
+
+func (r *DuelRecords) get(name string) *DuelRecord {
+	rec, ok := r.records[name]
+	if !ok {
+		rec = &DuelRecord{}
+		r.records[name] = rec
+	}
+	return rec
+}
+
+func (r *DuelRecords) save() error {
+	if r.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, data, 0644)
+}
+
+// Command runs a chat command sent by c: "/duel name" challenges a player
+// and "/accept" starts the duel the client was challenged to
+func (g *Game) Command(c *Client, text string) {
+	fields := strings.Fields(text)
+	switch strings.ToLower(fields[0]) {
+	case "/duel":
+		if len(fields) < 2 {
+			g.SystemMessageTo(c, "duel.usage")
+			return
+		}
+		g.Challenge(c, strings.Join(fields[1:], " "))
+	case "/accept":
+		g.AcceptDuel(c)
+	case "/spectate":
+		g.ToggleSpectator(c)
+	default:
+		g.SystemMessageTo(c, "command.unknown", fields[0])
+	}
+}
+
+// clientByName finds a connected client by its player name, ignoring case
+func (g *Game) clientByName(name string) *Client {
+	for c, ok := range g.clients {
+		if p, exist := g.Players[c.ID]; ok && exist && strings.EqualFold(strings.TrimSpace(p.Name), name) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (g *Game) playerName(c *Client) string {
+	if p, ok := g.Players[c.ID]; ok {
+		return strings.TrimSpace(p.Name)
+	}
+	return ""
+}
+
+func (g *Game) Challenge(from *Client, name string) {
+	to := g.clientByName(name)
+	switch {
+	case to == nil:
+		g.SystemMessageTo(from, "duel.not_found", name)
+	case to == from:
+		g.SystemMessageTo(from, "duel.self")
+	case to.bot != nil:
+		g.SystemMessageTo(from, "duel.bot")
+	case g.duels.Of(from) != nil || g.duels.Of(to) != nil || from.spectator || to.spectator:
+		g.SystemMessageTo(from, "duel.busy")
+	default:
+		g.duels.challenges[to] = challenge{from: from, expires: time.Now().Add(DuelChallengeTimeout)}
+		g.SystemMessageTo(from, "duel.sent", g.playerName(to))
+		g.SystemMessageTo(to, "duel.received", g.playerName(from))
+	}
+}
+
+func (g *Game) AcceptDuel(c *Client) {
+	ch, ok := g.duels.challenges[c]
+	delete(g.duels.challenges, c)
+	if !ok || time.Now().After(ch.expires) || !g.clients[ch.from] {
+		g.SystemMessageTo(c, "duel.no_challenge")
+		return
+	}
+	if g.duels.Of(c) != nil || g.duels.Of(ch.from) != nil || c.spectator || ch.from.spectator {
+		g.SystemMessageTo(c, "duel.busy")
+		return
+	}
+	d := &duel{
+		ID:      ksuid.New(),
+		State:   models.DuelCountdown,
+		Ends:    time.Now().Add(DuelCountdown),
+		players: [2]*Client{ch.from, c},
+		names:   [2]string{g.playerName(ch.from), g.playerName(c)},
+	}
+	for _, p := range d.players {
+		g.duels.running[p] = d
+	}
+	g.SystemMessage("duel.started", d.names[0], d.names[1])
+	g.sendDuel(d)
+}
+
+// UpdateDuels moves the duels forward, it runs once a second
+func (g *Game) UpdateDuels() {
+	now := time.Now()
+	for to, ch := range g.duels.challenges {
+		if now.After(ch.expires) {
+			delete(g.duels.challenges, to)
+		}
+	}
+	for c, d := range g.duels.running {
+		// every duel is listed once per duelist
+		if d.players[0] != c || now.Before(d.Ends) {
+			continue
+		}
+		switch d.State {
+		case models.DuelCountdown:
+			d.State = models.DuelFighting
+			d.Ends = now.Add(DuelTimeLimit)
+			g.sendDuel(d)
+		case models.DuelFighting:
+			g.SystemMessage("duel.draw", d.names[0], d.names[1])
+			g.endDuel(d, nil)
+		}
+	}
+}
+
+// DuelDeath ends the duel of the killed player once the fight started, it
+// reports if the death belonged to a duel so it stays out of the ranking
+func (g *Game) DuelDeath(killed ksuid.KSUID) bool {
+	for c, d := range g.duels.running {
+		if c.ID == killed && d.State == models.DuelFighting {
+			g.finishDuel(d, d.players[d.opponent(c)])
+			return true
+		}
+	}
+	return false
+}
+
+// forfeitDuel gives the duel to the opponent of a client that left
+func (g *Game) forfeitDuel(c *Client) {
+	delete(g.duels.challenges, c)
+	if d := g.duels.Of(c); d != nil {
+		g.finishDuel(d, d.players[d.opponent(c)])
+	}
+}
+
+func (g *Game) finishDuel(d *duel, winner *Client) {
+	w := 0
+	if d.players[1] == winner {
+		w = 1
+	}
+	wr, lr := duelRecords.Add(d.names[w], d.names[1-w])
+	g.SystemMessage("duel.won", d.names[w], d.names[1-w], wr.Wins, wr.Losses, lr.Wins, lr.Losses)
+	g.endDuel(d, winner)
+}
+
+func (g *Game) endDuel(d *duel, winner *Client) {
+	d.State = models.DuelEnded
+	for _, p := range d.players {
+		delete(g.duels.running, p)
+	}
+	msg := models.DuelMsg{ID: d.ID, State: d.State}
+	if winner != nil {
+		msg.Winner = winner.ID
+	}
+	payload, _ := json.Marshal(msg)
+	for _, p := range d.players {
+		if g.clients[p] {
+			p.push(models.NewMesg(models.Duel, payload))
+		}
+	}
+}
+
+// sendDuel tells both duelists the state of their duel
+func (g *Game) sendDuel(d *duel) {
+	for i, p := range d.players {
+		o := 1 - i
+		msg := models.DuelMsg{
+			ID:           d.ID,
+			State:        d.State,
+			Opponent:     d.players[o].ID,
+			OpponentName: d.names[o],
+			X:            duelSpots[i].X,
+			Y:            duelSpots[i].Y,
+			Remaining:    time.Until(d.Ends).Seconds(),
+		}
+		payload, _ := json.Marshal(msg)
+		p.push(models.NewMesg(models.Duel, payload))
+	}
+}
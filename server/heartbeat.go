@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/registry"
+)
+
+// SendHeartbeats keeps the server listed in the master server of the
+// config. The config is read on every beat, so reloads start, stop or move
+// the listing.
+func SendHeartbeats(lobby *Lobby) {
+	failing := false
+	for {
+		cfg := CurrentConfig()
+		if cfg.Master != "" {
+			hb := registry.Heartbeat{ServerInfoMsg: lobby.ServerInfo(), Addr: cfg.PublicAddr}
+			err := registry.Send(cfg.Master, cfg.MasterSecret, hb)
+			// only the changes are logged, not every beat
+			if err != nil && !failing {
+				slog.Warn("Heartbeat to the master failed", "master", cfg.Master, "err", err)
+			}
+			if err == nil && failing {
+				slog.Info("Listed in the master", "master", cfg.Master)
+			}
+			failing = err != nil
+		}
+		time.Sleep(registry.HeartbeatInterval)
+	}
+}
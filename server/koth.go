@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+const (
+	HillCaptureTime = 5 // seconds standing alone to take control
+)
+
+// KingOfTheHill awards a point every second to whoever controls the hill
+// and stands on it uncontested. With TeamPlay the holders are teams,
+// otherwise single players.
+type KingOfTheHill struct {
+	baseMode
+	TeamPlay    bool
+	PointsLimit int
+	Duration    time.Duration
+	hill        models.HillMsg
+	points      map[models.HillHolder]int
+}
+
+func NewKingOfTheHill(teamPlay bool) *KingOfTheHill {
+	return &KingOfTheHill{
+		TeamPlay:    teamPlay,
+		PointsLimit: 120,
+		Duration:    10 * time.Minute,
+		hill:        models.HillMsg{X: 2000, Y: 3300, Radius: 150},
+		points:      map[models.HillHolder]int{},
+	}
+}
+
+func (m *KingOfTheHill) Name() string {
+	if m.TeamPlay {
+		return "tkoth"
+	}
+	return "koth"
+}
+func (m *KingOfTheHill) Teams() bool              { return m.TeamPlay }
+func (m *KingOfTheHill) TimeLimit() time.Duration { return m.Duration }
+
+func (m *KingOfTheHill) Start(g *Game) {
+	m.hill.Controller = models.HillHolder{}
+	m.hill.Capturer = models.HillHolder{}
+	m.hill.Progress = 0
+	m.points = map[models.HillHolder]int{}
+	g.Broadcast(m.HillMsg())
+}
+
+// holder is who c stands on the hill for
+func (m *KingOfTheHill) holder(c *Client, p *models.PlayerMsg) models.HillHolder {
+	if m.TeamPlay {
+		return models.HillHolder{Team: c.team}
+	}
+	return models.HillHolder{ID: c.ID, Name: strings.TrimSpace(p.Name)}
+}
+
+func (m *KingOfTheHill) Tick(g *Game) {
+	occupants := map[models.HillHolder]bool{}
+	for c, ok := range g.clients {
+		p, exist := g.Players[c.ID]
+		if ok && exist && !p.Dead && models.InsideRadius(m.hill.X, m.hill.Y, p.X, p.Y, m.hill.Radius) {
+			occupants[m.holder(c, p)] = true
+		}
+	}
+	m.hill.Contested = len(occupants) > 1
+	if len(occupants) == 1 {
+		for h := range occupants {
+			m.occupy(h)
+		}
+	}
+	g.Broadcast(m.HillMsg())
+}
+
+// occupy advances the hill for a holder standing on it alone
+func (m *KingOfTheHill) occupy(h models.HillHolder) {
+	if h == m.hill.Controller {
+		m.points[h]++
+		m.hill.Capturer = h
+		m.hill.Progress = 1
+		return
+	}
+	if h != m.hill.Capturer {
+		m.hill.Capturer = h
+		m.hill.Progress = 0
+	}
+	m.hill.Progress += 1.0 / HillCaptureTime
+	if m.hill.Progress >= 1 {
+		m.hill.Progress = 1
+		m.hill.Controller = h
+	}
+}
+
+func (m *KingOfTheHill) Over(g *Game) bool {
+	for _, points := range m.points {
+		if points >= m.PointsLimit {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *KingOfTheHill) Result(g *Game) *models.MatchResultMsg {
+	r := &models.MatchResultMsg{Standings: g.Ranking.Standings()}
+	scores := m.scores()
+	if m.TeamPlay {
+		r.TeamScores = map[models.Team]int{}
+		for _, s := range scores {
+			r.TeamScores[s.Team] = s.Points
+		}
+		r.WinnerTeam = leadingTeam(r.TeamScores)
+	} else if len(scores) > 0 {
+		r.WinnerID = scores[0].ID
+		r.WinnerName = scores[0].Name
+	}
+	return r
+}
+
+// scores returns the points of every holder, best first
+func (m *KingOfTheHill) scores() []models.HillScoreMsg {
+	scores := []models.HillScoreMsg{}
+	for h, points := range m.points {
+		scores = append(scores, models.HillScoreMsg{HillHolder: h, Points: points})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].Points > scores[j].Points
+	})
+	return scores
+}
+
+func (m *KingOfTheHill) HillMsg() []byte {
+	hill := m.hill
+	hill.Scores = m.scores()
+	payload, _ := json.Marshal(hill)
+	return models.NewMesg(models.Hill, payload)
+}
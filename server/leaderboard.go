@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+const (
+	LeaderboardTop     = 10
+	LeaderboardMaxTop  = 50
+	RecentKills        = 20
+	LeaderboardRefresh = 2 * time.Second
+	// StatsSaveInterval throttles the saves of the all-time stats, kills
+	// happen too often to write the file on every one
+	StatsSaveInterval = 10 * time.Second
+)
+
+// PlayerStats are the all-time numbers of a player name
+type PlayerStats struct {
+	Name   string `json:"name"`
+	Kills  int    `json:"kills"`
+	Deaths int    `json:"deaths"`
+	Wins   int    `json:"wins"`
+}
+
+// KillMsg is a kill in the recent kills feed
+type KillMsg struct {
+	Time   time.Time `json:"time"`
+	Room   string    `json:"room"`
+	Killer string    `json:"killer"`
+	Killed string    `json:"killed"`
+}
+
+// AllTimeStats are the kills, deaths and match wins of every player name
+// over every room, kept in a json file. Bots are left out.
+type AllTimeStats struct {
+	path   string
+	stats  map[string]*PlayerStats
+	recent []KillMsg
+	saved  time.Time
+	dirty  bool
+	mutex  *sync.Mutex
+}
+
+var allTimeStats = &AllTimeStats{stats: map[string]*PlayerStats{}, mutex: &sync.Mutex{}}
+
+// Load reads the stats kept at path, a missing file is empty stats
+func (s *AllTimeStats) Load(path string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.path = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.stats)
+}
+
+// Kill counts a kill in room, bots don't get stats but show in the feed
+func (s *AllTimeStats) Kill(room string, d models.DeathMsg, killerBot, killedBot bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	killer, killed := strings.TrimSpace(d.KillerName), strings.TrimSpace(d.KilledName)
+	if killer != "" && !killerBot && d.Killer != d.Killed {
+		s.get(killer).Kills++
+	}
+	if killed != "" && !killedBot {
+		s.get(killed).Deaths++
+	}
+	s.recent = append(s.recent, KillMsg{Time: time.Now(), Room: room, Killer: killer, Killed: killed})
+	if len(s.recent) > RecentKills {
+		s.recent = s.recent[len(s.recent)-RecentKills:]
+	}
+	s.changed()
+}
+
+// Win counts a match won
+func (s *AllTimeStats) Win(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.get(strings.TrimSpace(name)).Wins++
+	s.changed()
+}
+
+func (s *AllTimeStats) get(name string) *PlayerStats {
+	p, ok := s.stats[name]
+	if !ok {
+		p = &PlayerStats{Name: name}
+		s.stats[name] = p
+	}
+	return p
+}
+
+func (s *AllTimeStats) changed() {
+	s.dirty = true
+	if time.Since(s.saved) > StatsSaveInterval {
+		s.save()
+	}
+}
+
+// Save writes the stats if they changed since the last save
+func (s *AllTimeStats) Save() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.save()
+}
+
+func (s *AllTimeStats) save() {
+	if s.path == "" || !s.dirty {
+		return
+	}
+	s.saved = time.Now()
+	s.dirty = false
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		slog.Error("Saving stats", "path", s.path, "err", err)
+		return
+	}
+	data, _ := json.MarshalIndent(s.stats, "", "  ")
+	if err := ioutil.WriteFile(s.path, data, 0644); err != nil {
+		slog.Error("Saving stats", "path", s.path, "err", err)
+	}
+}
+
+// Top returns the n players with more kills, and the recent kills newest
+// first
+func (s *AllTimeStats) Top(n int) ([]PlayerStats, []KillMsg) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	top := make([]PlayerStats, 0, len(s.stats))
+	for _, p := range s.stats {
+		top = append(top, *p)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Kills != top[j].Kills {
+			return top[i].Kills > top[j].Kills
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	recent := make([]KillMsg, len(s.recent))
+	for i := range s.recent {
+		recent[len(recent)-1-i] = s.recent[i]
+	}
+	return top, recent
+}
+
+// LeaderboardRoom is the live ranking of a room
+type LeaderboardRoom struct {
+	models.RoomMsg
+	Top []*models.RankingPosMsg `json:"top"`
+}
+
+// LeaderboardMsg is what the page shows, also served as json
+type LeaderboardMsg struct {
+	Rooms   []LeaderboardRoom `json:"rooms"`
+	AllTime []PlayerStats     `json:"all_time"`
+	Recent  []KillMsg         `json:"recent"`
+}
+
+// Leaderboard builds the leaderboard with the top n of each room
+func (l *Lobby) Leaderboard(n int) LeaderboardMsg {
+	m := LeaderboardMsg{Rooms: []LeaderboardRoom{}}
+	for _, room := range l.Rooms() {
+		g, ok := l.Room(room.Name)
+		if !ok {
+			continue
+		}
+		r := LeaderboardRoom{RoomMsg: room}
+		g.Do(func() {
+			ranking := Ranking{}
+			// ToMsg sorts the ranking the way clients see it
+			json.Unmarshal(models.UnmarshallMesg(g.Ranking.ToMsg()).Payload, &ranking)
+			r.Top = ranking
+		})
+		if len(r.Top) > n {
+			r.Top = r.Top[:n]
+		}
+		m.Rooms = append(m.Rooms, r)
+	}
+	m.AllTime, m.Recent = allTimeStats.Top(n)
+	return m
+}
+
+// ServeLeaderboard serves the leaderboard page on addr: / is the page,
+// /leaderboard.json the data and /events streams it as server-sent events
+func ServeLeaderboard(addr string, l *Lobby) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := leaderboardPage.Execute(w, l.Leaderboard(topParam(r))); err != nil {
+			slog.Warn("Rendering the leaderboard", "err", err)
+		}
+	})
+	mux.HandleFunc("/leaderboard.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l.Leaderboard(topParam(r)))
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		n := topParam(r)
+		tick := time.NewTicker(LeaderboardRefresh)
+		defer tick.Stop()
+		for {
+			data, _ := json.Marshal(l.Leaderboard(n))
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+			select {
+			case <-tick.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	slog.Info("Serving the leaderboard", "url", "http://"+addr+"/")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Serving the leaderboard", "err", err)
+	}
+}
+
+// topParam reads ?n= for the length of the tops
+func topParam(r *http.Request) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		return LeaderboardTop
+	}
+	if n > LeaderboardMaxTop {
+		return LeaderboardMaxTop
+	}
+	return n
+}
+
+var leaderboardPage = template.Must(template.New("leaderboard").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Go AO leaderboard</title>
+<style>
+body { font-family: monospace; background: #050a1e; color: #ddd; margin: 2em; }
+h1, h2 { color: #fff; }
+table { border-collapse: collapse; margin-bottom: 2em; min-width: 24em; }
+th, td { padding: .2em .8em; text-align: left; border-bottom: 1px solid #223; }
+.bot { color: #888; }
+#live { display: flex; flex-wrap: wrap; gap: 2em; }
+</style>
+</head>
+<body>
+<h1>Go AO</h1>
+<div id="live">
+{{range .Rooms}}
+<div>
+<h2>{{.Name}} <small>{{.Mode}}, {{.Players}} online</small></h2>
+<table>
+<tr><th>#</th><th>Name</th><th>K</th><th>D</th></tr>
+{{range $i, $p := .Top}}<tr{{if $p.Bot}} class="bot"{{end}}><td>{{inc $i}}</td><td>{{$p.Name}}{{if $p.Bot}} [bot]{{end}}</td><td>{{$p.K}}</td><td>{{$p.D}}</td></tr>
+{{end}}
+</table>
+</div>
+{{end}}
+</div>
+<h2>All time</h2>
+<table id="alltime">
+<tr><th>#</th><th>Name</th><th>Kills</th><th>Deaths</th><th>Wins</th></tr>
+{{range $i, $p := .AllTime}}<tr><td>{{inc $i}}</td><td>{{$p.Name}}</td><td>{{$p.Kills}}</td><td>{{$p.Deaths}}</td><td>{{$p.Wins}}</td></tr>
+{{end}}
+</table>
+<h2>Recent kills</h2>
+<table id="recent">
+{{range .Recent}}<tr><td>{{.Time.Format "15:04:05"}}</td><td>{{.Room}}</td><td>{{.Killer}} killed {{.Killed}}</td></tr>
+{{end}}
+</table>
+<script>
+function cell(row, text) {
+	var td = document.createElement("td");
+	td.textContent = text;
+	row.appendChild(td);
+}
+function table(headers) {
+	var t = document.createElement("table");
+	var tr = t.insertRow();
+	headers.forEach(function (h) {
+		var th = document.createElement("th");
+		th.textContent = h;
+		tr.appendChild(th);
+	});
+	return t;
+}
+function render(data) {
+	var live = document.getElementById("live");
+	live.innerHTML = "";
+	data.rooms.forEach(function (room) {
+		var div = document.createElement("div");
+		var h = document.createElement("h2");
+		h.textContent = room.name + " ";
+		var small = document.createElement("small");
+		small.textContent = room.mode + ", " + room.players + " online";
+		h.appendChild(small);
+		div.appendChild(h);
+		var t = table(["#", "Name", "K", "D"]);
+		(room.top || []).forEach(function (p, i) {
+			var tr = t.insertRow();
+			if (p.bot) tr.className = "bot";
+			cell(tr, i + 1);
+			cell(tr, p.name + (p.bot ? " [bot]" : ""));
+			cell(tr, p.kills);
+			cell(tr, p.deaths);
+		});
+		div.appendChild(t);
+		live.appendChild(div);
+	});
+	var all = table(["#", "Name", "Kills", "Deaths", "Wins"]);
+	all.id = "alltime";
+	(data.all_time || []).forEach(function (p, i) {
+		var tr = all.insertRow();
+		[i + 1, p.name, p.kills, p.deaths, p.wins].forEach(function (v) { cell(tr, v); });
+	});
+	document.getElementById("alltime").replaceWith(all);
+	var recent = document.createElement("table");
+	recent.id = "recent";
+	(data.recent || []).forEach(function (k) {
+		var tr = recent.insertRow();
+		cell(tr, new Date(k.time).toLocaleTimeString());
+		cell(tr, k.room);
+		cell(tr, k.killer + " killed " + k.killed);
+	});
+	document.getElementById("recent").replaceWith(recent);
+}
+new EventSource("events" + location.search).onmessage = function (e) {
+	render(JSON.parse(e.data));
+};
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/juanefec/go-pixel-ao/locale"
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+const (
+	MaxRooms       = 16
+	MaxRoomNameLen = 20
+	DefaultMap     = "forest"
+)
+
+// Lobby errors carry the catalog key sent back to the client
+var (
+	errNoRoom       = errors.New("lobby.no_room")
+	errRoomExists   = errors.New("lobby.room_exists")
+	errRoomName     = errors.New("lobby.bad_name")
+	errTooManyRooms = errors.New("lobby.too_many_rooms")
+	errUnknownMode  = errors.New("lobby.bad_mode")
+	errRoomFull     = errors.New("lobby.room_full")
+)
+
+// Lobby holds the rooms of the server, each one runs its own Game.
+// Clients stay in the lobby after the handshake until they join a room,
+// or until there is a place for them when they wait in the queue of a
+// full one.
+type Lobby struct {
+	// ReplayDir is given to every room created
+	ReplayDir string
+	rooms     map[string]*Game
+	clients   map[*Client]bool
+	queues    map[*Game][]*Client
+	mutex     *sync.RWMutex
+	// joinMutex lets a single join or admission from a queue run at a
+	// time, so two of them don't take the last place of a room
+	joinMutex *sync.Mutex
+}
+
+func NewLobby() *Lobby {
+	return &Lobby{
+		rooms:     make(map[string]*Game),
+		clients:   make(map[*Client]bool),
+		queues:    make(map[*Game][]*Client),
+		mutex:     &sync.RWMutex{},
+		joinMutex: &sync.Mutex{},
+	}
+}
+
+// Connect keeps track of a new connection until Disconnect
+func (l *Lobby) Connect(c *Client) {
+	l.mutex.Lock()
+	l.clients[c] = true
+	l.mutex.Unlock()
+}
+
+// Disconnect forgets c, it leaves the queue it was waiting in
+func (l *Lobby) Disconnect(c *Client) {
+	l.mutex.Lock()
+	delete(l.clients, c)
+	g := l.dequeue(c)
+	l.mutex.Unlock()
+	if g != nil {
+		l.sendPositions(g)
+	}
+}
+
+// Connections counts the clients connected, in a room or not
+func (l *Lobby) Connections() int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return len(l.clients)
+}
+
+// Clients lists the connections, in a room or not
+func (l *Lobby) Clients() []*Client {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	clients := make([]*Client, 0, len(l.clients))
+	for c := range l.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// Create starts a new room running mode with bots
+func (l *Lobby) Create(name string, mode GameMode, bots BotConfig) (*Game, error) {
+	if !validRoomName(name) {
+		return nil, errRoomName
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, exist := l.rooms[name]; exist {
+		return nil, errRoomExists
+	}
+	if len(l.rooms) >= MaxRooms {
+		return nil, errTooManyRooms
+	}
+	g := NewGame(name, mode)
+	g.ReplayDir = l.ReplayDir
+	g.Bots = bots
+	l.rooms[name] = g
+	go g.Run()
+	return g, nil
+}
+
+func (l *Lobby) Room(name string) (*Game, bool) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	g, ok := l.rooms[strings.TrimSpace(name)]
+	return g, ok
+}
+
+// Find returns the room a client asked to join, creating it first if
+// requested. A full room is returned along with errRoomFull, clients with
+// the bypass token get in anyway.
+func (l *Lobby) Find(req models.JoinRoomMsg, bypass bool) (*Game, error) {
+	if req.Create {
+		mode, err := ModeByName(req.Mode)
+		if err != nil {
+			return nil, errUnknownMode
+		}
+		return l.Create(req.Room, mode, BotConfig{})
+	}
+	g, ok := l.Room(req.Room)
+	if !ok {
+		return nil, errNoRoom
+	}
+	// nobody gets ahead of the ones waiting
+	if !bypass && (l.full(g) || l.Queued(g) > 0) {
+		return g, errRoomFull
+	}
+	return g, nil
+}
+
+// Join answers the JoinRoom request of c. When the room is full and c
+// asked to, c waits in its queue for a place.
+func (l *Lobby) Join(c *Client, req models.JoinRoomMsg) {
+	l.joinMutex.Lock()
+	// admitted from the queue while this request waited for joinMutex
+	if c.Game() != nil {
+		l.joinMutex.Unlock()
+		return
+	}
+	// a new request leaves the queue of the previous one
+	l.mutex.Lock()
+	left := l.dequeue(c)
+	l.mutex.Unlock()
+	g, err := l.Find(req, c.bypass)
+	queued, claimed := false, false
+	if err == errRoomFull && req.Queue {
+		if err = l.enqueue(c, g); err == nil {
+			queued = true
+		}
+	}
+	if err == nil && !queued {
+		// false once c disconnected, it gets nothing then
+		claimed = c.claim(g)
+	}
+	// nothing is sent under joinMutex, a slow client can't hold the others
+	l.joinMutex.Unlock()
+	if left != nil && !(queued && left == g) {
+		l.sendPositions(left)
+	}
+	switch {
+	case queued:
+		l.sendPositions(g)
+		c.log.Info("Queued", "room", g.Name)
+	case err != nil:
+		c.push(l.JoinReply(c, req, err))
+	case claimed:
+		c.enter(g, l.JoinReply(c, req, nil))
+	}
+}
+
+// full reports if g has as many players as it takes
+func (l *Lobby) full(g *Game) bool {
+	max := g.MaxPlayers()
+	return max > 0 && l.Players(g) >= max
+}
+
+// Queued counts the clients waiting for a place in g
+func (l *Lobby) Queued(g *Game) int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return len(l.queues[g])
+}
+
+// enqueue puts c at the end of the queue of g, errRoomFull if the queue is
+// full too
+func (l *Lobby) enqueue(c *Client, g *Game) error {
+	l.mutex.Lock()
+	if max := CurrentConfig().MaxQueue; max > 0 && len(l.queues[g]) >= max {
+		l.mutex.Unlock()
+		return errRoomFull
+	}
+	l.queues[g] = append(l.queues[g], c)
+	l.mutex.Unlock()
+	return nil
+}
+
+// dequeue takes c out of the queue it is in and returns the room it was
+// waiting for, nil if it wasn't waiting. It runs under mutex.
+func (l *Lobby) dequeue(c *Client) *Game {
+	for g, q := range l.queues {
+		for i := range q {
+			if q[i] == c {
+				l.queues[g] = append(q[:i:i], q[i+1:]...)
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// admit lets the clients waiting for g in while it has places
+func (l *Lobby) admit(g *Game) {
+	l.joinMutex.Lock()
+	admitted := []*Client{}
+	for !l.full(g) {
+		l.mutex.Lock()
+		q := l.queues[g]
+		if len(q) == 0 {
+			l.mutex.Unlock()
+			break
+		}
+		c := q[0]
+		l.queues[g] = q[1:]
+		l.mutex.Unlock()
+		if c.claim(g) {
+			admitted = append(admitted, c)
+		}
+	}
+	l.joinMutex.Unlock()
+	for _, c := range admitted {
+		c.enter(g, l.JoinReply(c, models.JoinRoomMsg{Room: g.Name, Queue: true}, nil))
+	}
+	if len(admitted) > 0 {
+		l.sendPositions(g)
+	}
+}
+
+// sendPositions tells the clients waiting for g their place in the queue.
+// A client too busy to take the update gets the next one.
+func (l *Lobby) sendPositions(g *Game) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for i, c := range l.queues[g] {
+		reply := l.JoinReply(c, models.JoinRoomMsg{Room: g.Name, Queue: true, Queued: i + 1}, nil)
+		select {
+		case c.send <- reply:
+		default:
+		}
+	}
+}
+
+// Players counts the connections in g, bots are not connections
+func (l *Lobby) Players(g *Game) int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	n := 0
+	for c := range l.clients {
+		c.gameMutex.RLock()
+		if c.game == g {
+			n++
+		}
+		c.gameMutex.RUnlock()
+	}
+	return n
+}
+
+// ApplyRooms creates the rooms of the config that don't exist yet and
+// updates the player limit of the ones that do
+func (l *Lobby) ApplyRooms(rooms []RoomConfig) error {
+	var errs []error
+	for _, r := range rooms {
+		mode, bots, err := r.Parse()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		g, exist := l.Room(r.Name)
+		if exist && g.Mode.Name() != mode.Name() {
+			slog.Warn("Room mode change needs a restart", "room", g.Name)
+		}
+		if !exist {
+			if g, err = l.Create(r.Name, mode, bots); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			slog.Info("Room created", "room", g.Name, "mode", mode.Name())
+		}
+		g.SetMaxPlayers(r.MaxPlayers)
+	}
+	// the limits may have gone up
+	for _, g := range l.Games() {
+		l.admit(g)
+	}
+	return errors.Join(errs...)
+}
+
+// Games returns every room
+func (l *Lobby) Games() []*Game {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	games := make([]*Game, 0, len(l.rooms))
+	for _, g := range l.rooms {
+		games = append(games, g)
+	}
+	return games
+}
+
+// Rooms lists the rooms sorted by name
+func (l *Lobby) Rooms() []models.RoomMsg {
+	l.mutex.RLock()
+	rooms := make([]models.RoomMsg, 0, len(l.rooms))
+	for _, g := range l.rooms {
+		r := g.RoomMsg()
+		r.MaxPlayers = g.MaxPlayers()
+		r.Queued = len(l.queues[g])
+		rooms = append(rooms, r)
+	}
+	l.mutex.RUnlock()
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms
+}
+
+func (l *Lobby) RoomsMsg() []byte {
+	payload, _ := json.Marshal(l.Rooms())
+	return models.NewMesg(models.Lobby, payload)
+}
+
+// JoinReply answers a JoinRoom request, err is translated to the locale of c
+func (l *Lobby) JoinReply(c *Client, req models.JoinRoomMsg, err error) []byte {
+	req.Error = ""
+	if err != nil {
+		req.Error = locale.T(c.locale, err.Error())
+	}
+	payload, _ := json.Marshal(req)
+	return models.NewMesg(models.JoinRoom, payload)
+}
+
+// reap ends g once nobody is in it or waiting for it, the rooms of the
+// config stay
+func (l *Lobby) reap(g *Game) {
+	for _, r := range CurrentConfig().Rooms {
+		if r.Name == g.Name {
+			return
+		}
+	}
+	// joinMutex keeps anybody from joining in the meantime
+	l.joinMutex.Lock()
+	if l.Players(g) > 0 || l.Queued(g) > 0 {
+		l.joinMutex.Unlock()
+		return
+	}
+	l.mutex.Lock()
+	if l.rooms[g.Name] == g {
+		delete(l.rooms, g.Name)
+	}
+	delete(l.queues, g)
+	l.mutex.Unlock()
+	l.joinMutex.Unlock()
+	g.End()
+	slog.Info("Empty room removed", "room", g.Name)
+}
+
+// End stops every room and waits for them
+func (l *Lobby) End() {
+	for _, g := range l.Games() {
+		g.End()
+	}
+	allTimeStats.Save()
+}
+
+// validRoomName accepts short printable ASCII names, the client font
+// can't draw anything else. A slash would end the name in the admin API
+// paths.
+func validRoomName(name string) bool {
+	if name == "" || len(name) > MaxRoomNameLen || name != strings.TrimSpace(name) {
+		return false
+	}
+	for _, r := range name {
+		if r < ' ' || r > '~' || r == '/' {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *Game) RoomMsg() models.RoomMsg {
+	g.Pmutex.RLock()
+	defer g.Pmutex.RUnlock()
+	return models.RoomMsg{
+		Name:    g.Name,
+		Mode:    g.Mode.Name(),
+		Map:     g.Map,
+		Players: len(g.Players),
+	}
+}
+
+// SetMaxPlayers limits the players of the room, 0 uses the server
+// max_players
+func (g *Game) SetMaxPlayers(n int) {
+	g.Pmutex.Lock()
+	g.maxPlayers = n
+	g.Pmutex.Unlock()
+}
+
+// MaxPlayers is how many players fit in the room, 0 for no limit
+func (g *Game) MaxPlayers() int {
+	g.Pmutex.RLock()
+	defer g.Pmutex.RUnlock()
+	if g.maxPlayers > 0 {
+		return g.maxPlayers
+	}
+	return CurrentConfig().MaxPlayers
+}
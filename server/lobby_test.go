@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/juanefec/go-pixel-ao/locale"
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+// testClient is a client without a connection, connected to l
+func testClient(l *Lobby) *Client {
+	c := &Client{ID: ksuid.New(), lobby: l, send: make(chan []byte, 64), registered: make(chan struct{}), confirmed: make(chan struct{}), locale: locale.Default, gameMutex: &sync.RWMutex{}, closeOnce: &sync.Once{}, log: slog.Default()}
+	l.Connect(c)
+	return c
+}
+
+func TestRejoinWhileAdmitted(t *testing.T) {
+	SetConfig(DefaultConfig())
+	mode, _ := ModeByName("ffa")
+	req := models.JoinRoomMsg{Room: "main", Queue: true}
+	for i := 0; i < 100; i++ {
+		l := NewLobby()
+		g, err := l.Create(req.Room, mode, BotConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.SetMaxPlayers(2)
+		players := []*Client{testClient(l), testClient(l)}
+		for _, p := range players {
+			p.claim(g)
+		}
+		c := testClient(l)
+		l.Join(c, req)
+		if l.Queued(g) != 1 {
+			t.Fatalf("queued %d, want 1", l.Queued(g))
+		}
+		// both places free up while c asks again
+		for _, p := range players {
+			l.Disconnect(p)
+		}
+		done := make(chan struct{})
+		go func() {
+			l.Join(c, req)
+			close(done)
+		}()
+		l.admit(g)
+		<-done
+		if n, q := l.Players(g), l.Queued(g); n != 1 || q != 0 {
+			t.Fatalf("%d players and %d queued, want 1 and 0", n, q)
+		}
+		g.End()
+	}
+}
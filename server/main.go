@@ -4,62 +4,154 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
-	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/juanefec/go-pixel-ao/locale"
+	"github.com/juanefec/go-pixel-ao/logging"
 	"github.com/juanefec/go-pixel-ao/models"
 	"github.com/segmentio/ksuid"
 )
 
 func main() {
 
-	port := 33333
+	cfg, err := LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		logging.Fatal("Bad config", "err", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		logging.Fatal("Bad config", "err", err)
+	}
+	SetConfig(cfg)
+
+	if _, err := logging.Setup(logging.Options{Level: cfg.LogLevel, Format: cfg.LogFormat}); err != nil {
+		logging.Fatal("Bad logging config", "err", err)
+	}
 
-	SocketServer(port)
+	if err := duelRecords.Load(filepath.Join(cfg.DataDir, "duels.json")); err != nil {
+		logging.Fatal("Loading duel records", "err", err)
+	}
+	if err := bans.Load(filepath.Join(cfg.DataDir, "bans.json")); err != nil {
+		logging.Fatal("Loading bans", "err", err)
+	}
+	if err := allTimeStats.Load(filepath.Join(cfg.DataDir, "stats.json")); err != nil {
+		logging.Fatal("Loading stats", "err", err)
+	}
+
+	lobby := NewLobby()
+	lobby.ReplayDir = cfg.ReplayDir
+	if err := lobby.ApplyRooms(cfg.Rooms); err != nil {
+		logging.Fatal("Creating rooms", "err", err)
+	}
+	if cfg.Metrics != "" {
+		go ServeMetrics(cfg.Metrics, lobby, cfg.Pprof)
+	}
+	if cfg.Web != "" {
+		go ServeLeaderboard(cfg.Web, lobby)
+	}
+	if cfg.Admin != "" {
+		go ServeAdmin(cfg.Admin, NewAdmin(lobby, func() string {
+			return CurrentConfig().AdminToken
+		}))
+	}
+	if cfg.Discovery {
+		go ServeDiscovery(cfg, lobby)
+	}
+	go SendHeartbeats(lobby)
+	go reloadOnHangup(os.Args[1:], lobby)
+
+	if cfg.Restore {
+		if err := lobby.Restore(filepath.Join(cfg.DataDir, StateFile)); err != nil {
+			slog.Error("Restoring the rooms", "err", err)
+		}
+	}
+
+	SocketServer(cfg, lobby)
+	lobby.Shutdown(filepath.Join(cfg.DataDir, StateFile))
+
+}
 
+// reloadOnHangup reloads the config on every SIGHUP
+func reloadOnHangup(args []string, lobby *Lobby) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := Reload(args, lobby); err != nil {
+			slog.Error("Config reload failed, the running config is kept", "err", err)
+			continue
+		}
+		slog.Info("Config reloaded")
+	}
 }
 
-func SocketServer(port int) {
+// SocketServer accepts players until a SIGINT or SIGTERM closes the listener
+func SocketServer(cfg *Config, lobby *Lobby) {
 
-	listen, err := net.Listen("tcp4", ":"+strconv.Itoa(port))
+	network, addr := cfg.Addr()
+	listen, err := net.Listen(network, addr)
 
 	if err != nil {
-		log.Fatalf("Socket listen port %d failed,%s", port, err)
-		os.Exit(1)
+		logging.Fatal("Socket listen failed", "addr", addr, "err", err)
 	}
 
 	defer listen.Close()
 
-	log.Printf("Begin listen port: %d", port)
+	slog.Info("Listening", "addr", listen.Addr().String())
 
-	game := NewGame()
-	defer game.End()
-	go game.Run()
+	go closeOnSignal(listen)
 
 	for {
 		conn, err := listen.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
-			log.Fatalln(err)
+			slog.Error("Accept failed", "err", err)
 			continue
 		}
-		log.Printf("Connected to: %v", conn.RemoteAddr().String())
-		go ServeGame(&conn, game)
+		slog.Debug("Connected", "remote", conn.RemoteAddr().String())
+		// past max_connections the server refuses instead of slowing down
+		// for everybody
+		if max := CurrentConfig().MaxConnections; max > 0 && lobby.Connections() >= max {
+			slog.Debug("Refused client, too many connections", "remote", conn.RemoteAddr().String())
+			metrics.Disconnected(DisconnectOverload)
+			conn.Close()
+			continue
+		}
+		if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil && bans.Banned(host) {
+			slog.Info("Refused banned client", "remote", host)
+			metrics.Disconnected(DisconnectBanned)
+			conn.Close()
+			continue
+		}
+		go ServeGame(&conn, lobby)
 	}
 
 }
 
-// ServeGame handles websocket requests from the peer.
-func ServeGame(conn *net.Conn, game *Game) {
+// ServeGame handles websocket requests from the peer, the client stays in
+// the lobby until it joins a room.
+func ServeGame(conn *net.Conn, lobby *Lobby) {
 	id := ksuid.New()
-	client := &Client{ID: id, game: game, conn: conn, send: make(chan []byte, 1024), hasRecivedID: false}
-	lastSent := time.Now()
+	client := &Client{ID: id, lobby: lobby, conn: conn, send: make(chan []byte, 1024), registered: make(chan struct{}), confirmed: make(chan struct{}), locale: locale.Default, gameMutex: &sync.RWMutex{}, closeOnce: &sync.Once{}}
+	client.log = slog.With("client", id.String(), "remote", (*conn).RemoteAddr().String())
+	lobby.Connect(client)
 	client.send <- []byte(client.ID.String())
-	log.Printf("Sengind ID: %v", client.ID.String())
+	client.log.Debug("Sending ID")
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
 	go client.writePump()
@@ -67,27 +159,31 @@ func ServeGame(conn *net.Conn, game *Game) {
 
 	maxRetrys := 10
 	//Verify ID reception
-	rn := 0
-	for !client.hasRecivedID {
-		if rn < maxRetrys {
-			dt := time.Since(lastSent)
-			if dt > time.Second {
-				rn++
-				lastSent = time.Now()
-				client.send <- []byte(client.ID.String())
-				log.Printf("Retrying ID: %v", client.ID.String())
-			}
-		} else {
-			break
+	retry := time.NewTicker(time.Second)
+	defer retry.Stop()
+	for rn := 1; rn <= maxRetrys; rn++ {
+		select {
+		case <-client.confirmed:
+			client.pushConnected(lobby.RoomsMsg())
+			return
+		case <-retry.C:
 		}
+		client.pushConnected([]byte(client.ID.String()))
+		client.log.Debug("Retrying ID", "try", rn)
 	}
-	client.game.register <- client
+	client.log.Warn("Handshake failed, the ID was never confirmed")
+	metrics.HandshakeFailed()
+	client.Close(DisconnectHandshake)
 }
 
 var (
 	Newline = []byte{'\n'}
 )
 
+// WriteTimeout is how long a write to a client can block, a client that
+// stopped reading is disconnected after it
+const WriteTimeout = 10 * time.Second
+
 type Ranking []*models.RankingPosMsg
 
 func (r Ranking) ToMsg() []byte {
@@ -130,7 +226,7 @@ func (r *Ranking) Update(payload json.RawMessage) {
 		}
 		*r = append(*r, rr)
 	}
-	if !killerExist {
+	if !killerExist && d.Killer != ksuid.Nil {
 		rr := &models.RankingPosMsg{
 			ID:   d.Killer,
 			Name: d.KillerName,
@@ -142,19 +238,150 @@ func (r *Ranking) Update(payload json.RawMessage) {
 }
 
 type Client struct {
-	ID           ksuid.KSUID
-	game         *Game
-	conn         *net.Conn
-	send         chan []byte
-	hasRecivedID bool
+	rtt         int64 // nanoseconds, first to be aligned for atomic
+	ID          ksuid.KSUID
+	lobby       *Lobby
+	game        *Game // nil while in the lobby
+	gameMutex   *sync.RWMutex
+	conn        *net.Conn
+	send        chan []byte
+	locale      locale.Locale
+	team        models.Team
+	spectator   bool
+	bot         *Bot // nil for players
+	closeOnce   *sync.Once
+	closeReason string
+	log         *slog.Logger // tagged with the client id and address
+	// bypass is set when the handshake had the bypass token, c skips the
+	// player limits
+	bypass bool
+	// profile is the id the client remembers itself by, it takes back its
+	// ranking place after a restart
+	profile string
+	// left is set under gameMutex once readPump ends, c can't enter a
+	// room after
+	left bool
+	// registered is closed once c is registered in its room, readPump
+	// waits for it before sending anything to the room
+	registered chan struct{}
+	// confirmed is closed by readPump when the handshake arrives
+	confirmed chan struct{}
+}
+
+// Close disconnects the client, the first reason given is the one counted
+func (c *Client) Close(reason string) {
+	c.closeOnce.Do(func() {
+		c.closeReason = reason
+	})
+	(*c.conn).Close()
+}
+
+// closeAfterSend disconnects the client once what is queued for it is
+// written. It runs in the room loop, the one closing c.send.
+func (c *Client) closeAfterSend(reason string) {
+	c.closeOnce.Do(func() {
+		c.closeReason = reason
+	})
+	select {
+	case c.send <- nil:
+	default:
+		c.Close(reason)
+	}
+}
+
+// Room is the name of the room the client is in, empty in the lobby
+func (c *Client) Room() string {
+	if g := c.Game(); g != nil {
+		return g.Name
+	}
+	return ""
+}
+
+// Game is the room the client is in, nil in the lobby. It is set from the
+// queue of a full room too, not only by the pumps.
+func (c *Client) Game() *Game {
+	c.gameMutex.RLock()
+	defer c.gameMutex.RUnlock()
+	return c.game
+}
+
+// claim sets g as the room of c, it returns false if c disconnected in the
+// meantime. Clients are admitted from the queues by other goroutines,
+// gameMutex keeps them in order with the disconnection. A claimed client
+// counts as a player of g, enter has to follow once the lobby is unlocked.
+func (c *Client) claim(g *Game) bool {
+	c.gameMutex.Lock()
+	defer c.gameMutex.Unlock()
+	if c.left {
+		return false
+	}
+	c.game = g
+	return true
+}
+
+// enter sends reply to c and registers it in g, claimed before
+func (c *Client) enter(g *Game, reply []byte) {
+	// registered after the reply so the client leaves the lobby screen
+	// before the room starts sending
+	c.push(reply)
+	enqueue(g, g.register, c)
+	close(c.registered)
+	c.log.Info("Joined room", "room", g.Name)
+}
+
+// pushConnected pushes msg unless readPump ended and closed send, for the
+// goroutines sending to c besides the room and the lobby
+func (c *Client) pushConnected(msg []byte) {
+	c.gameMutex.RLock()
+	defer c.gameMutex.RUnlock()
+	if !c.left {
+		c.push(msg)
+	}
+}
+
+// push queues msg for c without blocking the room, a client that can't
+// keep up is disconnected instead of slowing everybody down
+func (c *Client) push(msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		// bots have no connection, they only miss the message
+		if c.conn == nil {
+			return
+		}
+		c.closeOnce.Do(func() {
+			c.closeReason = DisconnectSlow
+			c.log.Warn("Disconnecting a client too slow to keep up", "queued", len(c.send))
+		})
+		(*c.conn).Close()
+	}
 }
 
 func (c *Client) readPump() {
+	reason := DisconnectClosed
 	defer func() {
-		log.Printf("Disconnected: %v", (*c.conn).RemoteAddr().String())
-		log.Printf("Exited Client.readPump: %v", c.ID)
-		c.game.unregister <- c
-		(*c.conn).Close()
+		c.lobby.Disconnect(c)
+		c.gameMutex.Lock()
+		c.left = true
+		g := c.game
+		c.gameMutex.Unlock()
+		if g != nil {
+			<-c.registered
+		}
+		if g == nil {
+			close(c.send)
+		} else if !enqueue(g, g.unregister, c) {
+			// the room ended, nothing else sends to c once it stopped
+			<-g.stopped
+			close(c.send)
+		}
+		if g != nil {
+			c.lobby.admit(g)
+			c.lobby.reap(g)
+		}
+		c.Close(reason)
+		metrics.Disconnected(c.closeReason)
+		c.log.Info("Disconnected", "reason", c.closeReason, "room", c.Room())
 	}()
 	var (
 		data bytes.Buffer
@@ -164,7 +391,12 @@ func (c *Client) readPump() {
 	for {
 		dataRead, isPrefix, err := r.ReadLine()
 		if err != nil {
-			log.Printf("Error: %v", err.Error())
+			c.log.Debug("Read failed", "err", err)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				reason = DisconnectTimeout
+			} else if err != io.EOF {
+				reason = DisconnectReadError
+			}
 			break
 
 		}
@@ -173,45 +405,104 @@ func (c *Client) readPump() {
 		if isPrefix {
 			continue
 		}
+		metrics.Message(models.ReplayIn, data.Bytes())
 		msg := models.UnmarshallMesg(data.Bytes())
+		g := c.Game()
+		if g == nil {
+			c.lobbyMessage(msg)
+			data = bytes.Buffer{}
+			continue
+		}
+		<-c.registered
+		g.record(models.ReplayIn, c, data.Bytes())
 		switch msg.Type {
 		case models.Chat, models.Spell:
-			c.game.eventBroadcast <- BroadcastEvent{
+			if msg.Type == models.Spell {
+				enqueue(g, g.spellCasts, msg.Payload)
+			}
+			if msg.Type == models.Chat && isCommand(msg.Payload) {
+				enqueue(g, g.commands, BroadcastEvent{
+					Client:  c,
+					Event:   msg.Type,
+					Payload: msg.Payload})
+				break
+			}
+			enqueue(g, g.eventBroadcast, BroadcastEvent{
 				Client:  c,
 				Event:   msg.Type,
-				Payload: msg.Payload}
+				Payload: msg.Payload})
 			break
 		case models.UpdateServer:
-			c.game.clientsUpdate <- BroadcastEvent{
+			enqueue(g, g.clientsUpdate, BroadcastEvent{
 				Client:  c,
 				Event:   msg.Type,
-				Payload: msg.Payload}
+				Payload: msg.Payload})
 			break
 		case models.Death:
-			c.game.Ranking.Update(msg.Payload)
-			break
-		case models.ConfirmIDReception:
-			println("recived confimation of id reception ahre")
-			c.hasRecivedID = true
+			enqueue(g, g.deaths, BroadcastEvent{
+				Client:  c,
+				Event:   msg.Type,
+				Payload: msg.Payload})
 			break
+		case models.Ping:
+			c.pong(msg.Payload)
 		}
 		data = bytes.Buffer{}
 	}
 
 }
 
+// lobbyMessage handles what a client sends before joining a room
+func (c *Client) lobbyMessage(msg *models.Mesg) {
+	switch msg.Type {
+	case models.ConfirmIDReception:
+		hs := models.HandshakeMsg{}
+		if err := json.Unmarshal(msg.Payload, &hs); err == nil {
+			c.locale = locale.Parse(hs.Locale)
+			c.bypass = isBypassToken(hs.BypassToken)
+			if len(hs.Profile) <= MaxProfileLen {
+				c.profile = hs.Profile
+			}
+		}
+		c.log.Info("Connected", "locale", c.locale, "bypass", c.bypass)
+		select {
+		case <-c.confirmed:
+		default:
+			close(c.confirmed)
+		}
+	case models.Lobby:
+		c.push(c.lobby.RoomsMsg())
+	case models.JoinRoom:
+		req := models.JoinRoomMsg{}
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return
+		}
+		c.lobby.Join(c, req)
+	}
+}
+
 func (c *Client) writePump() {
 	defer func() {
-		log.Printf("Exited Client.writePump: %v", c.ID)
 		(*c.conn).Close()
 	}()
 	var w = bufio.NewWriter(*c.conn)
 
 	for msg := range c.send {
+		// nil is queued by closeAfterSend, everything before it was written
+		if msg == nil {
+			return
+		}
+		// the room is read under lock, it is set while this runs
+		if g := c.Game(); g != nil {
+			g.record(models.ReplayOut, c, msg)
+		}
+		metrics.Message(models.ReplayOut, msg)
 		msg = makeMessage(msg)
 		w.Write(msg)
+		(*c.conn).SetWriteDeadline(time.Now().Add(WriteTimeout))
 		if err := w.Flush(); err != nil {
-			log.Printf("Error: %v", err.Error())
+			c.log.Debug("Write failed", "err", err)
+			c.Close(DisconnectWriteErr)
 			return
 		}
 		//log.Printf("Send: %v|END", string(message))
@@ -231,7 +522,12 @@ type BroadcastEvent struct {
 }
 
 type Game struct {
+	Name           string
+	Map            string
 	Online         int
+	FriendlyFire   bool
+	Mode           GameMode
+	Match          Match
 	Ranking        Ranking
 	Players        map[ksuid.KSUID]*models.PlayerMsg
 	Pmutex         *sync.RWMutex
@@ -240,11 +536,37 @@ type Game struct {
 	register       chan *Client
 	unregister     chan *Client
 	eventBroadcast chan BroadcastEvent
+	deaths         chan BroadcastEvent
+	commands       chan BroadcastEvent
+	spellCasts     chan json.RawMessage
+	actions        chan func()
+	duels          *Duels
+	// Bots are spawned when the room starts
+	Bots   BotConfig
+	bots   map[ksuid.KSUID]*Bot
+	spells []*activeSpell
+	// ReplayDir is where matches are recorded, empty to not record them
+	ReplayDir string
+	recorder  *Recorder
+	recMutex  *sync.Mutex
+	// maxPlayers is read under Pmutex, 0 for the server max_players
+	maxPlayers int
+	// unclaimed are the profiles of the restored ranking places nobody took
+	// back yet, by ranking id, they are dropped at claimBy
+	unclaimed map[ksuid.KSUID]string
+	claimBy   time.Time
+	// quit asks the loop to stop, stopped is closed once it did
+	quit    chan struct{}
+	stopped chan struct{}
+	endOnce *sync.Once
 }
 
-func NewGame() *Game {
+func NewGame(name string, mode GameMode) *Game {
 	return &Game{
+		Name:           name,
+		Map:            DefaultMap,
 		Online:         0,
+		Mode:           mode,
 		Ranking:        make(Ranking, 0),
 		Players:        make(map[ksuid.KSUID]*models.PlayerMsg),
 		clientsUpdate:  make(chan BroadcastEvent),
@@ -253,59 +575,121 @@ func NewGame() *Game {
 		unregister:     make(chan *Client),
 		clients:        make(map[*Client]bool),
 		eventBroadcast: make(chan BroadcastEvent),
+		deaths:         make(chan BroadcastEvent),
+		commands:       make(chan BroadcastEvent),
+		spellCasts:     make(chan json.RawMessage),
+		actions:        make(chan func()),
+		bots:           make(map[ksuid.KSUID]*Bot),
+		duels:          NewDuels(),
+		recMutex:       &sync.Mutex{},
+		quit:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+		endOnce:        &sync.Once{},
 	}
 }
 
+// End stops the room and waits for it. The channels of the loop are left
+// open, the pumps of the clients still in the room may be sending to them.
 func (g *Game) End() {
-	close(g.clientsUpdate)
-	close(g.register)
-	close(g.unregister)
+	g.endOnce.Do(func() {
+		close(g.quit)
+	})
+	<-g.stopped
 }
 
-func (g *Game) Run() {
-	go func() {
-		rankingUpdater := time.Tick(time.Second)
-		for {
-			select {
-			case event := <-g.eventBroadcast:
-				for c, ok := range g.clients {
-					if ok && c.ID != event.Client.ID {
-						c.send <- models.NewMesg(event.Event, event.Payload)
-					}
-				}
-				if event.Event == models.Disconect {
-					delete(g.clients, event.Client)
-				}
-			case <-rankingUpdater:
-				for c, ok := range g.clients {
-					if ok {
-						c.send <- g.Ranking.ToMsg()
-					}
-				}
-			}
+// enqueue sends v to a channel of the room loop, it gives up and returns
+// false once the room ended
+func enqueue[T any](g *Game, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-g.quit:
+		return false
+	}
+}
+
+// stop is the last thing the loop runs, once nothing else sends to the
+// clients of the room
+func (g *Game) stop() {
+	g.StopRecording()
+	for _, b := range g.bots {
+		close(b.client.send)
+	}
+	close(g.stopped)
+}
+
+// broadcastEvent sends what a client did to everybody else in the room
+func (g *Game) broadcastEvent(event BroadcastEvent) {
+	for c, ok := range g.clients {
+		if ok && c.ID != event.Client.ID {
+			c.push(models.NewMesg(event.Event, event.Payload))
 		}
-	}()
+	}
+}
+
+func (g *Game) Run() {
+	g.SpawnBots()
 
+	cfg := CurrentConfig()
+	tick := cfg.Tick()
+	rankingUpdater := time.Tick(time.Duration(cfg.RankingInterval))
+	// the rules count in seconds, the match keeps its own pace
+	matchUpdater := time.Tick(time.Second)
 	logger := time.Tick(time.Second * 5)
+	botUpdater := time.Tick(tick)
+	pinger := time.Tick(time.Duration(cfg.PingInterval))
 	for {
 		select {
+		case <-rankingUpdater:
+			g.Broadcast(g.Ranking.ToMsg())
+
+		case <-matchUpdater:
+			start := time.Now()
+			g.UpdateMatch()
+			g.UpdateDuels()
+			g.dropUnclaimed()
+			metrics.Tick(g.Name, "match", time.Since(start))
+
+		case event := <-g.eventBroadcast:
+			g.broadcastEvent(event)
+
+		case msg := <-g.deaths:
+			g.Death(msg.Client, msg.Payload)
+
 		case msg := <-g.clientsUpdate:
-			g.UpdateServer(msg)
-			msg.Client.send <- g.UpdateClient(msg.Client)
+			g.clientUpdate(msg)
+
+		case payload := <-g.spellCasts:
+			s := models.SpellMsg{}
+			if err := json.Unmarshal(payload, &s); err == nil {
+				g.CastSpell(s)
+			}
+
+		case <-botUpdater:
+			start := time.Now()
+			g.UpdateBots(tick.Seconds())
+			metrics.Tick(g.Name, "bots", time.Since(start))
+
+		case cmd := <-g.commands:
+			chat := models.ChatMsg{}
+			if err := json.Unmarshal(cmd.Payload, &chat); err == nil {
+				g.Command(cmd.Client, chat.Message)
+			}
 
 		case client := <-g.register:
-			g.clients[client] = true
+			g.Register(client)
 
 		case client := <-g.unregister:
 			if _, ok := g.clients[client]; ok {
-				g.clients[client] = false
+				g.forfeitDuel(client)
 				p := models.DisconectMsg{ID: client.ID}
 				payload, _ := json.Marshal(p)
-				g.eventBroadcast <- BroadcastEvent{
+				g.broadcastEvent(BroadcastEvent{
 					Client:  client,
 					Event:   models.Disconect,
 					Payload: payload,
-				}
+				})
+				delete(g.clients, client)
 				for i := range g.Ranking {
 					if g.Ranking[i].ID == client.ID {
 						g.Ranking[i] = g.Ranking[len(g.Ranking)-1]
@@ -315,33 +699,175 @@ func (g *Game) Run() {
 					}
 				}
 
+				g.Mode.OnLeave(g, client)
+				if p, ok := g.Players[client.ID]; ok {
+					g.SystemMessage("system.left", strings.TrimSpace(p.Name))
+				}
+				g.Pmutex.Lock()
 				delete(g.Players, client.ID)
+				g.Pmutex.Unlock()
 				close(client.send)
 			}
 
+		case <-pinger:
+			g.Broadcast(PingMsg())
+
+		case f := <-g.actions:
+			f()
+
 		case <-logger:
-			log.Println("player list len: ", len(g.Players))
+			slog.Debug("Room players", "room", g.Name, "players", len(g.Players))
+
+		case <-g.quit:
+			g.stop()
+			return
 		}
 
 	}
 }
 
-func (g *Game) UpdateServer(message BroadcastEvent) {
+// Register adds a client to the room
+func (g *Game) Register(c *Client) {
+	if g.Mode.Teams() {
+		c.team = g.nextTeam()
+	}
+	g.clients[c] = true
+	c.push(g.RulesMsg())
+	c.push(g.MatchMsg())
+	if motd := CurrentConfig().MOTD; motd != "" && c.bot == nil {
+		payload, _ := json.Marshal(models.SystemMsg{Text: motd})
+		c.push(models.NewMesg(models.System, payload))
+	}
+}
+
+// Death counts a kill reported by a client or a bot killed by the server
+func (g *Game) Death(sender *Client, payload json.RawMessage) {
+	d := models.DeathMsg{}
+	if err := json.Unmarshal(payload, &d); err != nil {
+		return
+	}
+	// clients only report their own death, sender is nil for the ones the
+	// server resolves
+	if sender != nil && d.Killed != sender.ID {
+		sender.log.Warn("Death of another player reported", "killed", d.Killed.String())
+		return
+	}
+	if g.DuelDeath(d.Killed) {
+		return
+	}
+	// the kill feed for everybody, the game client ignores it
+	g.Broadcast(models.NewMesg(models.Death, payload))
+	_, killerBot := g.bots[d.Killer]
+	_, killedBot := g.bots[d.Killed]
+	allTimeStats.Kill(g.Name, d, killerBot, killedBot)
+	g.Ranking.Update(payload)
+	for _, r := range g.Ranking {
+		_, r.Bot = g.bots[r.ID]
+	}
+	if g.Match.State == models.MatchRunning {
+		g.Mode.OnDeath(g, d)
+	}
+}
+
+// clientUpdate stores a player update and answers with everybody else,
+// bots need no answer
+func (g *Game) clientUpdate(msg BroadcastEvent) {
+	p := g.UpdateServer(msg)
+	if p != nil && g.Match.State == models.MatchRunning {
+		g.Mode.OnPlayerUpdate(g, msg.Client, p)
+	}
+	if msg.Client.bot == nil {
+		msg.Client.push(g.UpdateClient(msg.Client))
+	}
+}
+
+// nextTeam picks the team with fewer connected clients
+func (g *Game) nextTeam() models.Team {
+	count := map[models.Team]int{}
+	for c, ok := range g.clients {
+		if ok {
+			count[c.team]++
+		}
+	}
+	if count[models.BlueTeam] < count[models.RedTeam] {
+		return models.BlueTeam
+	}
+	return models.RedTeam
+}
+
+func (g *Game) RulesMsg() []byte {
+	payload, _ := json.Marshal(models.RulesMsg{FriendlyFire: g.FriendlyFire})
+	return models.NewMesg(models.Rules, payload)
+}
+
+// Broadcast sends msg to every connected client
+func (g *Game) Broadcast(msg []byte) {
+	for c, ok := range g.clients {
+		if ok {
+			c.push(msg)
+		}
+	}
+}
+
+// SystemMessage sends a catalog message to every client, translated to
+// the locale each one declared in the handshake
+func (g *Game) SystemMessage(key string, args ...interface{}) {
+	for c, ok := range g.clients {
+		if ok {
+			g.SystemMessageTo(c, key, args...)
+		}
+	}
+}
+
+// SystemMessageTo sends a catalog message to c alone
+func (g *Game) SystemMessageTo(c *Client, key string, args ...interface{}) {
+	payload, _ := json.Marshal(models.SystemMsg{Text: locale.T(c.locale, key, args...)})
+	c.push(models.NewMesg(models.System, payload))
+}
+
+// isCommand reports if a chat message is a command for the server, those
+// are not broadcast
+func isCommand(payload json.RawMessage) bool {
+	chat := models.ChatMsg{}
+	if err := json.Unmarshal(payload, &chat); err != nil {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(chat.Message), "/")
+}
+
+// UpdateServer stores the state a client sent about its player and returns it
+func (g *Game) UpdateServer(message BroadcastEvent) *models.PlayerMsg {
 	var msg models.PlayerMsg
 	err := json.Unmarshal(message.Payload, &msg)
 	if err == nil {
 
+		msg.Team = message.Client.team
+		msg.Bot = message.Client.bot != nil
+		// spectators are out of the game whatever their client says
+		if message.Client.spectator {
+			msg.Spectator = true
+			msg.Dead = true
+		}
+		if d := g.duels.Of(message.Client); d != nil {
+			msg.Duel = d.ID
+			msg.DuelFighting = d.State == models.DuelFighting
+		}
 		g.Pmutex.Lock()
-		if _, ok := g.Players[msg.ID]; !ok {
+		_, exist := g.Players[msg.ID]
+		if !exist {
 			g.Online++
 		}
 		g.Players[msg.ID] = &msg
 		g.Pmutex.Unlock()
-
-	} else {
-		log.Printf("err: %v", err.Error())
+		if !exist {
+			g.SystemMessage("system.joined", strings.TrimSpace(msg.Name))
+			g.claimRanking(message.Client, &msg)
+		}
+		return &msg
 
 	}
+	message.Client.log.Warn("Bad player update", "err", err)
+	return nil
 }
 
 func (g *Game) UpdateClient(c *Client) []byte {
@@ -362,3 +888,16 @@ func getPlayerList(m map[ksuid.KSUID]*models.PlayerMsg) []*models.PlayerMsg {
 	}
 	return res
 }
+
+// ToggleSpectator moves c between playing and spectating, a duel in course
+// is lost and a carried flag dropped like on a disconnection
+func (g *Game) ToggleSpectator(c *Client) {
+	c.spectator = !c.spectator
+	if c.spectator {
+		g.forfeitDuel(c)
+		g.Mode.OnLeave(g, c)
+		g.SystemMessageTo(c, "spectate.on")
+		return
+	}
+	g.SystemMessageTo(c, "spectate.off")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+)
+
+// Disconnect reasons
+const (
+	DisconnectClosed    = "closed"
+	DisconnectTimeout   = "timeout"
+	DisconnectReadError = "read_error"
+	DisconnectWriteErr  = "write_error"
+	DisconnectHandshake = "handshake"
+	DisconnectKicked    = "kicked"
+	DisconnectBanned    = "banned"
+	DisconnectShutdown  = "shutdown"
+	DisconnectSlow      = "slow"
+	DisconnectOverload  = "overload"
+)
+
+// tickBuckets are the upper bounds of the tick duration histogram
+var tickBuckets = []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1}
+
+type histogram struct {
+	counts []int64 // per bucket, not cumulative
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(tickBuckets))
+	}
+	for i, le := range tickBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type messageKey struct {
+	dir   models.ReplayDirection
+	event string
+}
+
+type tickKey struct {
+	room, tick string
+}
+
+// Metrics counts what the server does, it is exposed in the Prometheus
+// text format when the server runs with -metrics
+type Metrics struct {
+	mutex             *sync.Mutex
+	messages          map[messageKey]int64
+	bytes             map[messageKey]int64
+	ticks             map[tickKey]*histogram
+	handshakeFailures int64
+	disconnects       map[string]int64
+}
+
+var metrics = NewMetrics()
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		mutex:       &sync.Mutex{},
+		messages:    map[messageKey]int64{},
+		bytes:       map[messageKey]int64{},
+		ticks:       map[tickKey]*histogram{},
+		disconnects: map[string]int64{},
+	}
+}
+
+// Message counts a message sent or received
+func (m *Metrics) Message(dir models.ReplayDirection, msg []byte) {
+	k := messageKey{dir: dir, event: eventName(msg)}
+	m.mutex.Lock()
+	m.messages[k]++
+	m.bytes[k] += int64(len(msg))
+	m.mutex.Unlock()
+}
+
+// Tick records how long a tick of the room loop took
+func (m *Metrics) Tick(room, tick string, d time.Duration) {
+	m.mutex.Lock()
+	k := tickKey{room: room, tick: tick}
+	h, ok := m.ticks[k]
+	if !ok {
+		h = &histogram{}
+		m.ticks[k] = h
+	}
+	h.observe(d.Seconds())
+	m.mutex.Unlock()
+}
+
+func (m *Metrics) HandshakeFailed() {
+	m.mutex.Lock()
+	m.handshakeFailures++
+	m.mutex.Unlock()
+}
+
+func (m *Metrics) Disconnected(reason string) {
+	m.mutex.Lock()
+	m.disconnects[reason]++
+	m.mutex.Unlock()
+}
+
+// eventName reads the event of a message without decoding all of it,
+// messages look like {"event":2,"payload":...}
+func eventName(msg []byte) string {
+	prefix := []byte(`{"event":`)
+	if !bytes.HasPrefix(msg, prefix) {
+		return "raw"
+	}
+	end := len(prefix)
+	for end < len(msg) && msg[end] >= '0' && msg[end] <= '9' {
+		end++
+	}
+	n, err := strconv.Atoi(string(msg[len(prefix):end]))
+	if err != nil || n < 0 || n > int(models.Ping) {
+		return "unknown"
+	}
+	return models.Event(n).String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+// Write writes every metric of the server, the room and client gauges
+// are read from the lobby
+func (m *Metrics) Write(w io.Writer, l *Lobby) {
+	header(w, "goao_players_online", "gauge", "Players in each room.")
+	for _, r := range l.Rooms() {
+		fmt.Fprintf(w, "goao_players_online{room=\"%v\",mode=\"%v\"} %d\n", label(r.Name), r.Mode, r.Players)
+	}
+	clients := l.Clients()
+	header(w, "goao_clients_connected", "gauge", "Connections, in a room or in the lobby.")
+	fmt.Fprintf(w, "goao_clients_connected %d\n", len(clients))
+	header(w, "goao_client_send_queue", "gauge", "Messages waiting to be sent to each client.")
+	for _, c := range clients {
+		fmt.Fprintf(w, "goao_client_send_queue{client=\"%v\",room=\"%v\"} %d\n", c.ID, label(c.Room()), len(c.send))
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys := make([]messageKey, 0, len(m.messages))
+	for k := range m.messages {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].dir < keys[j].dir || keys[i].dir == keys[j].dir && keys[i].event < keys[j].event
+	})
+	header(w, "goao_messages_total", "counter", "Messages by direction and event.")
+	for _, k := range keys {
+		fmt.Fprintf(w, "goao_messages_total{direction=\"%v\",event=\"%v\"} %d\n", k.dir, k.event, m.messages[k])
+	}
+	header(w, "goao_message_bytes_total", "counter", "Message bytes by direction and event.")
+	for _, k := range keys {
+		fmt.Fprintf(w, "goao_message_bytes_total{direction=\"%v\",event=\"%v\"} %d\n", k.dir, k.event, m.bytes[k])
+	}
+
+	header(w, "goao_tick_duration_seconds", "histogram", "Time spent in each tick of the room loops.")
+	ticks := make([]tickKey, 0, len(m.ticks))
+	for k := range m.ticks {
+		ticks = append(ticks, k)
+	}
+	sort.Slice(ticks, func(i, j int) bool {
+		return ticks[i].room < ticks[j].room || ticks[i].room == ticks[j].room && ticks[i].tick < ticks[j].tick
+	})
+	for _, k := range ticks {
+		h := m.ticks[k]
+		labels := fmt.Sprintf("room=\"%v\",tick=\"%v\"", label(k.room), k.tick)
+		cumulative := int64(0)
+		for i, le := range tickBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "goao_tick_duration_seconds_bucket{%v,le=\"%v\"} %d\n", labels, le, cumulative)
+		}
+		fmt.Fprintf(w, "goao_tick_duration_seconds_bucket{%v,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "goao_tick_duration_seconds_sum{%v} %v\n", labels, h.sum)
+		fmt.Fprintf(w, "goao_tick_duration_seconds_count{%v} %d\n", labels, h.count)
+	}
+
+	header(w, "goao_handshake_failures_total", "counter", "Clients that never confirmed their id.")
+	fmt.Fprintf(w, "goao_handshake_failures_total %d\n", m.handshakeFailures)
+	reasons := make([]string, 0, len(m.disconnects))
+	for r := range m.disconnects {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	header(w, "goao_disconnects_total", "counter", "Disconnections by reason.")
+	for _, r := range reasons {
+		fmt.Fprintf(w, "goao_disconnects_total{reason=\"%v\"} %d\n", r, m.disconnects[r])
+	}
+}
+
+// ServeMetrics serves /metrics on addr, and the pprof handlers under
+// /debug/pprof/ if enabled
+func ServeMetrics(addr string, l *Lobby, profiling bool) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.Write(w, l)
+	})
+	if profiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	slog.Info("Serving metrics", "url", "http://"+addr+"/metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Serving metrics", "err", err)
+	}
+}
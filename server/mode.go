@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	MatchMinPlayers   = 2
+	MatchCountdown    = 10 * time.Second
+	MatchResultsShown = 15 * time.Second
+)
+
+// GameMode decides how a match is played and who wins it
+type GameMode interface {
+	// Name is the id clients use to show the mode
+	Name() string
+	// Teams reports if players are split in teams
+	Teams() bool
+	// Respawn reports if dead players may respawn while the match is running
+	Respawn() bool
+	TimeLimit() time.Duration
+	// Start is called when the match begins, right after the ranking reset
+	Start(g *Game)
+	// OnDeath is called for every kill while the match is running
+	OnDeath(g *Game, d models.DeathMsg)
+	// OnPlayerUpdate is called for every player update while the match is running
+	OnPlayerUpdate(g *Game, c *Client, p *models.PlayerMsg)
+	// OnLeave is called when a client disconnects
+	OnLeave(g *Game, c *Client)
+	// Tick is called once a second while the match is running
+	Tick(g *Game)
+	// Over reports if the score limit was reached
+	Over(g *Game) bool
+	Result(g *Game) *models.MatchResultMsg
+}
+
+// RestorableMode is a mode whose running match carries over a restart of
+// the server, the matches of the other modes start over
+type RestorableMode interface {
+	GameMode
+	// Scores is what the mode counts besides the ranking
+	Scores() map[models.Team]int
+	RestoreScores(scores map[models.Team]int)
+}
+
+// ModeByName builds a new GameMode from its name
+func ModeByName(name string) (GameMode, error) {
+	switch name {
+	case "ffa":
+		return NewDeathmatch(), nil
+	case "tdm":
+		return NewTeamDeathmatch(), nil
+	case "ctf":
+		return NewCaptureTheFlag(), nil
+	case "br":
+		return NewBattleRoyale(), nil
+	case "koth":
+		return NewKingOfTheHill(false), nil
+	case "tkoth":
+		return NewKingOfTheHill(true), nil
+	}
+	return nil, fmt.Errorf("unknown game mode %q", name)
+}
+
+// baseMode has the no-op hooks modes can embed
+type baseMode struct{}
+
+func (baseMode) Respawn() bool                                          { return true }
+func (baseMode) Start(g *Game)                                          {}
+func (baseMode) OnDeath(g *Game, d models.DeathMsg)                     {}
+func (baseMode) OnPlayerUpdate(g *Game, c *Client, p *models.PlayerMsg) {}
+func (baseMode) OnLeave(g *Game, c *Client)                             {}
+func (baseMode) Tick(g *Game)                                           {}
+
+// Deathmatch is a free-for-all won by the first to reach the kill limit
+type Deathmatch struct {
+	baseMode
+	KillLimit int
+	Duration  time.Duration
+}
+
+func NewDeathmatch() *Deathmatch {
+	return &Deathmatch{KillLimit: 20, Duration: 10 * time.Minute}
+}
+
+func (m *Deathmatch) Name() string             { return "ffa" }
+func (m *Deathmatch) Teams() bool              { return false }
+func (m *Deathmatch) TimeLimit() time.Duration { return m.Duration }
+
+// the ranking is all a deathmatch counts
+func (m *Deathmatch) Scores() map[models.Team]int              { return nil }
+func (m *Deathmatch) RestoreScores(scores map[models.Team]int) {}
+
+func (m *Deathmatch) Over(g *Game) bool {
+	for i := range g.Ranking {
+		if g.Ranking[i].K >= m.KillLimit {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Deathmatch) Result(g *Game) *models.MatchResultMsg {
+	r := &models.MatchResultMsg{Standings: g.Ranking.Standings()}
+	if len(r.Standings) > 0 {
+		r.WinnerID = r.Standings[0].ID
+		r.WinnerName = r.Standings[0].Name
+	}
+	return r
+}
+
+// TeamDeathmatch is won by the team that gets more kills
+type TeamDeathmatch struct {
+	baseMode
+	KillLimit int
+	Duration  time.Duration
+	scores    map[models.Team]int
+}
+
+func NewTeamDeathmatch() *TeamDeathmatch {
+	return &TeamDeathmatch{KillLimit: 40, Duration: 10 * time.Minute}
+}
+
+func (m *TeamDeathmatch) Name() string             { return "tdm" }
+func (m *TeamDeathmatch) Teams() bool              { return true }
+func (m *TeamDeathmatch) TimeLimit() time.Duration { return m.Duration }
+
+func (m *TeamDeathmatch) Start(g *Game) {
+	m.scores = map[models.Team]int{models.RedTeam: 0, models.BlueTeam: 0}
+}
+
+func (m *TeamDeathmatch) OnDeath(g *Game, d models.DeathMsg) {
+	killer, killed := g.TeamOf(d.Killer), g.TeamOf(d.Killed)
+	if killer != models.NoTeam && killer != killed {
+		m.scores[killer]++
+	}
+}
+
+func (m *TeamDeathmatch) Scores() map[models.Team]int {
+	scores := map[models.Team]int{}
+	for t, score := range m.scores {
+		scores[t] = score
+	}
+	return scores
+}
+
+func (m *TeamDeathmatch) RestoreScores(scores map[models.Team]int) {
+	for t, score := range scores {
+		m.scores[t] = score
+	}
+}
+
+func (m *TeamDeathmatch) Over(g *Game) bool {
+	for _, score := range m.scores {
+		if score >= m.KillLimit {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *TeamDeathmatch) Result(g *Game) *models.MatchResultMsg {
+	r := &models.MatchResultMsg{
+		Standings:  g.Ranking.Standings(),
+		TeamScores: map[models.Team]int{},
+	}
+	for t, score := range m.scores {
+		r.TeamScores[t] = score
+	}
+	r.WinnerTeam = leadingTeam(r.TeamScores)
+	return r
+}
+
+// leadingTeam returns the team with the highest score, NoTeam on a draw
+func leadingTeam(scores map[models.Team]int) models.Team {
+	red, blue := scores[models.RedTeam], scores[models.BlueTeam]
+	switch {
+	case red > blue:
+		return models.RedTeam
+	case blue > red:
+		return models.BlueTeam
+	}
+	return models.NoTeam
+}
+
+// Match holds the state machine every mode goes through:
+// waiting -> countdown -> running -> ended -> waiting
+type Match struct {
+	State  models.MatchState
+	Ends   time.Time
+	Result *models.MatchResultMsg
+}
+
+// Standings returns a sorted copy of the ranking
+func (r Ranking) Standings() []*models.RankingPosMsg {
+	s := make([]*models.RankingPosMsg, len(r))
+	copy(s, r)
+	sort.Slice(s, func(i, j int) bool {
+		return s[i].K > s[j].K
+	})
+	return s
+}
+
+// TeamOf returns the team of the connected client with id
+func (g *Game) TeamOf(id ksuid.KSUID) models.Team {
+	for c, ok := range g.clients {
+		if ok && c.ID == id {
+			return c.team
+		}
+	}
+	return models.NoTeam
+}
+
+// connected counts the clients playing, spectators don't count
+func (g *Game) connected() int {
+	n := 0
+	for c, ok := range g.clients {
+		if ok && !c.spectator {
+			n++
+		}
+	}
+	return n
+}
+
+// UpdateMatch moves the match forward, it runs once a second
+func (g *Game) UpdateMatch() {
+	now := time.Now()
+	switch g.Match.State {
+	case models.MatchWaiting:
+		if g.connected() >= MatchMinPlayers {
+			g.Match.State = models.MatchCountdown
+			g.Match.Ends = now.Add(MatchCountdown)
+		}
+	case models.MatchCountdown:
+		if g.connected() < MatchMinPlayers {
+			g.Match.State = models.MatchWaiting
+		} else if now.After(g.Match.Ends) {
+			g.Ranking = make(Ranking, 0)
+			g.StartRecording()
+			g.Mode.Start(g)
+			if !g.Mode.Respawn() {
+				g.ReviveBots()
+			}
+			g.Match.State = models.MatchRunning
+			g.Match.Ends = now.Add(g.Mode.TimeLimit())
+		}
+	case models.MatchRunning:
+		g.Mode.Tick(g)
+		if now.After(g.Match.Ends) || g.Mode.Over(g) {
+			g.Match.Result = g.Mode.Result(g)
+			if _, bot := g.bots[g.Match.Result.WinnerID]; g.Match.Result.WinnerName != "" && !bot {
+				allTimeStats.Win(g.Match.Result.WinnerName)
+			}
+			g.Match.State = models.MatchEnded
+			g.Match.Ends = now.Add(MatchResultsShown)
+		}
+	case models.MatchEnded:
+		if now.After(g.Match.Ends) {
+			g.Match.Result = nil
+			g.Match.State = models.MatchWaiting
+			g.StopRecording()
+		}
+	}
+	g.Broadcast(g.MatchMsg())
+}
+
+func (g *Game) MatchMsg() []byte {
+	m := models.MatchMsg{
+		Mode:      g.Mode.Name(),
+		State:     g.Match.State,
+		NoRespawn: g.Match.State == models.MatchRunning && !g.Mode.Respawn(),
+		Result:    g.Match.Result,
+	}
+	if g.Match.State != models.MatchWaiting {
+		m.Remaining = time.Until(g.Match.Ends).Seconds()
+	}
+	payload, _ := json.Marshal(m)
+	return models.NewMesg(models.Match, payload)
+}
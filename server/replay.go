@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+// Recorder writes every message of a match to a replay file, it is used
+// from the client pumps and the game loop at the same time
+type Recorder struct {
+	Path  string
+	mutex *sync.Mutex
+	file  *os.File
+	buf   *bufio.Writer
+	gz    *gzip.Writer
+	enc   *json.Encoder
+	start time.Time
+}
+
+// NewRecorder creates a replay file in dir and writes its header
+func NewRecorder(dir string, h models.ReplayHeader) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%v-%v.replay.gz", replayName(h.Room), h.Started.Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		Path:  path,
+		mutex: &sync.Mutex{},
+		file:  file,
+		start: h.Started,
+	}
+	r.buf = bufio.NewWriter(file)
+	r.gz = gzip.NewWriter(r.buf)
+	r.enc = json.NewEncoder(r.gz)
+	if err := r.enc.Encode(h); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record appends a message sent to or received from client, messages that
+// are not json are left out
+func (r *Recorder) Record(dir models.ReplayDirection, client ksuid.KSUID, msg []byte) {
+	if r == nil || !json.Valid(msg) {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.enc == nil {
+		return
+	}
+	e := models.ReplayEntry{
+		At:     time.Since(r.start).Milliseconds(),
+		Dir:    dir,
+		Client: client,
+		Mesg:   json.RawMessage(msg),
+	}
+	if err := r.enc.Encode(e); err != nil {
+		slog.Error("Recording", "path", r.Path, "err", err)
+	}
+}
+
+// Close flushes the replay, later records are dropped
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.enc = nil
+	if err := r.gz.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	if err := r.buf.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// replayName keeps room names safe to use in a file name
+func replayName(room string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, room)
+}
+
+// StartRecording begins a replay of the match if the room records them
+func (g *Game) StartRecording() {
+	if g.ReplayDir == "" {
+		return
+	}
+	h := models.ReplayHeader{
+		Version: models.ProtocolVersion,
+		Room:    g.Name,
+		Mode:    g.Mode.Name(),
+		Map:     g.Map,
+		Seed:    models.ForestSeed,
+		Started: time.Now(),
+		Roster:  []models.ReplayRosterMsg{},
+	}
+	g.Pmutex.RLock()
+	for c, ok := range g.clients {
+		// bots are left out, a replay is watched from a player's view
+		if p, exist := g.Players[c.ID]; ok && exist && c.bot == nil {
+			h.Roster = append(h.Roster, models.ReplayRosterMsg{ID: c.ID, Name: strings.TrimSpace(p.Name), Team: c.team, Class: p.Class})
+		}
+	}
+	g.Pmutex.RUnlock()
+	r, err := NewRecorder(g.ReplayDir, h)
+	if err != nil {
+		slog.Error("Starting replay", "room", g.Name, "err", err)
+		return
+	}
+	slog.Info("Recording", "room", g.Name, "path", r.Path)
+	g.recMutex.Lock()
+	g.recorder = r
+	g.recMutex.Unlock()
+}
+
+func (g *Game) StopRecording() {
+	g.recMutex.Lock()
+	r := g.recorder
+	g.recorder = nil
+	g.recMutex.Unlock()
+	if r == nil {
+		return
+	}
+	if err := r.Close(); err != nil {
+		slog.Error("Closing replay", "path", r.Path, "err", err)
+	}
+}
+
+// record adds msg to the replay of the running match, if any
+func (g *Game) record(dir models.ReplayDirection, c *Client, msg []byte) {
+	g.recMutex.Lock()
+	r := g.recorder
+	g.recMutex.Unlock()
+	r.Record(dir, c.ID, msg)
+}
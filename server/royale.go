@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// RoyaleGrace keeps the round alive while revived players report back
+	RoyaleGrace = 5 * time.Second
+	MapSize     = 4000.0
+)
+
+type zonePhase struct {
+	Wait   time.Duration // before it starts shrinking
+	Shrink time.Duration
+	Radius float64 // at the end of the phase
+	Damage float64 // per second outside of the zone
+}
+
+var royalePhases = []zonePhase{
+	{Wait: 60 * time.Second, Shrink: 30 * time.Second, Radius: 1800, Damage: 5},
+	{Wait: 45 * time.Second, Shrink: 30 * time.Second, Radius: 1100, Damage: 10},
+	{Wait: 40 * time.Second, Shrink: 25 * time.Second, Radius: 600, Damage: 20},
+	{Wait: 30 * time.Second, Shrink: 20 * time.Second, Radius: 250, Damage: 35},
+	{Wait: 20 * time.Second, Shrink: 15 * time.Second, Radius: 0, Damage: 60},
+}
+
+// safeZone shrinks from its current circle to the next one every phase
+type safeZone struct {
+	X, Y, Radius             float64
+	fromX, fromY, fromRadius float64
+	NextX, NextY, NextRadius float64
+	phase                    int
+	phaseStart               time.Time
+}
+
+func (z *safeZone) reset() {
+	z.X, z.Y, z.Radius = MapSize/2, MapSize/2, MapSize*0.75
+	z.phase = 0
+	z.startPhase()
+}
+
+// startPhase picks the next circle inside the current one
+func (z *safeZone) startPhase() {
+	z.fromX, z.fromY, z.fromRadius = z.X, z.Y, z.Radius
+	z.phaseStart = time.Now()
+	p := royalePhases[z.phase]
+	slack := z.Radius - p.Radius
+	angle := rand.Float64() * 2 * math.Pi
+	offset := rand.Float64() * slack
+	z.NextX = clamp(z.X+math.Cos(angle)*offset, p.Radius, MapSize-p.Radius)
+	z.NextY = clamp(z.Y+math.Sin(angle)*offset, p.Radius, MapSize-p.Radius)
+	z.NextRadius = p.Radius
+}
+
+// update moves the zone along the current phase
+func (z *safeZone) update() {
+	p := royalePhases[z.phase]
+	elapsed := time.Since(z.phaseStart)
+	switch {
+	case elapsed < p.Wait:
+	case elapsed < p.Wait+p.Shrink:
+		t := float64(elapsed-p.Wait) / float64(p.Shrink)
+		z.X = z.fromX + (z.NextX-z.fromX)*t
+		z.Y = z.fromY + (z.NextY-z.fromY)*t
+		z.Radius = z.fromRadius + (z.NextRadius-z.fromRadius)*t
+	default:
+		z.X, z.Y, z.Radius = z.NextX, z.NextY, z.NextRadius
+		if z.phase < len(royalePhases)-1 {
+			z.phase++
+			z.startPhase()
+		}
+	}
+}
+
+func (z *safeZone) Msg() models.ZoneMsg {
+	p := royalePhases[z.phase]
+	next := p.Wait - time.Since(z.phaseStart)
+	if next < 0 {
+		next = 0
+	}
+	return models.ZoneMsg{
+		X:          z.X,
+		Y:          z.Y,
+		Radius:     z.Radius,
+		NextX:      z.NextX,
+		NextY:      z.NextY,
+		NextRadius: z.NextRadius,
+		NextShrink: next.Seconds(),
+		Damage:     p.Damage,
+	}
+}
+
+// BattleRoyale is won by the last wizard standing, nobody respawns until
+// the round is over and the safe zone keeps shrinking
+type BattleRoyale struct {
+	baseMode
+	Duration time.Duration
+	zone     safeZone
+	started  time.Time
+}
+
+func NewBattleRoyale() *BattleRoyale {
+	m := &BattleRoyale{Duration: 10 * time.Minute}
+	m.zone.reset()
+	return m
+}
+
+func (m *BattleRoyale) Name() string             { return "br" }
+func (m *BattleRoyale) Teams() bool              { return false }
+func (m *BattleRoyale) TimeLimit() time.Duration { return m.Duration }
+func (m *BattleRoyale) Respawn() bool            { return false }
+
+func (m *BattleRoyale) Start(g *Game) {
+	m.started = time.Now()
+	m.zone.reset()
+	g.Broadcast(m.ZoneMsg())
+}
+
+func (m *BattleRoyale) Tick(g *Game) {
+	m.zone.update()
+	zone := m.zone.Msg()
+	if time.Since(m.started) > RoyaleGrace {
+		dmg := models.DamageMsg{Amount: zone.Damage, Reason: "zone"}
+		for c, ok := range g.clients {
+			p, exist := g.Players[c.ID]
+			if ok && exist && !p.Dead && !models.InsideRadius(zone.X, zone.Y, p.X, p.Y, zone.Radius) {
+				g.Damage(c, dmg)
+			}
+		}
+	}
+	g.Broadcast(m.ZoneMsg())
+}
+
+// alive returns the ids of the connected players still standing
+func (m *BattleRoyale) alive(g *Game) []ksuid.KSUID {
+	ids := []ksuid.KSUID{}
+	for c, ok := range g.clients {
+		if p, exist := g.Players[c.ID]; ok && exist && !p.Dead {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids
+}
+
+func (m *BattleRoyale) Over(g *Game) bool {
+	return time.Since(m.started) > RoyaleGrace && len(m.alive(g)) <= 1
+}
+
+func (m *BattleRoyale) Result(g *Game) *models.MatchResultMsg {
+	r := &models.MatchResultMsg{Standings: g.Ranking.Standings()}
+	if alive := m.alive(g); len(alive) == 1 {
+		r.WinnerID = alive[0]
+		r.WinnerName = g.Players[alive[0]].Name
+	}
+	return r
+}
+
+func (m *BattleRoyale) ZoneMsg() []byte {
+	payload, _ := json.Marshal(m.zone.Msg())
+	return models.NewMesg(models.Zone, payload)
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// StateFile keeps the rooms in the data dir from a shutdown to the next
+	// start
+	StateFile = "state.json"
+	// RestoreMaxAge is how old a saved state is restored, the players of an
+	// older one are long gone
+	RestoreMaxAge = 10 * time.Minute
+	// ShutdownDrain is how long the last messages get to reach the players,
+	// the connections still open after it are closed anyway
+	ShutdownDrain = 5 * time.Second
+	// ClaimGrace is how long the players have after a restore to take back
+	// their ranking places, the ones left are dropped
+	ClaimGrace = 2 * time.Minute
+	// MaxProfileLen is the longest profile id taken from a handshake
+	MaxProfileLen = 64
+)
+
+// SavedState is what is kept of the rooms when the server shuts down
+type SavedState struct {
+	Saved time.Time   `json:"saved"`
+	Rooms []RoomState `json:"rooms"`
+}
+
+// RoomState is a room as it was on the shutdown. Remaining and Scores are
+// only kept for the running matches of a RestorableMode. Profiles are the
+// profile ids of the players in the ranking, by ranking id.
+type RoomState struct {
+	Name      string                 `json:"name"`
+	Mode      string                 `json:"mode"`
+	Match     models.MatchState      `json:"match"`
+	Remaining Duration               `json:"remaining"`
+	Scores    map[models.Team]int    `json:"scores,omitempty"`
+	Ranking   Ranking                `json:"ranking"`
+	Profiles  map[ksuid.KSUID]string `json:"profiles,omitempty"`
+}
+
+// closeOnSignal closes the listener on the first SIGINT or SIGTERM, which
+// starts the shutdown, a second one exits right away
+func closeOnSignal(listen net.Listener) {
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	slog.Info("Shutting down, signal again to exit now", "signal", sig.String())
+	listen.Close()
+	sig = <-stop
+	slog.Warn("Exited before the shutdown finished", "signal", sig.String())
+	os.Exit(1)
+}
+
+// Shutdown warns the players with a countdown, saves the rooms to path for
+// the next start and disconnects everybody
+func (l *Lobby) Shutdown(path string) {
+	countdown := int(time.Duration(CurrentConfig().ShutdownCountdown).Round(time.Second).Seconds())
+	for left := countdown; left > 0; left-- {
+		if left == countdown || left <= 5 || left%10 == 0 {
+			l.SystemMessage("system.shutdown", left)
+		}
+		time.Sleep(time.Second)
+	}
+	if err := l.SaveState(path); err != nil {
+		slog.Error("Saving the rooms", "path", path, "err", err)
+	}
+	l.disconnectAll()
+	l.End()
+	slog.Info("Shut down")
+}
+
+// SystemMessage sends a catalog message to every room
+func (l *Lobby) SystemMessage(key string, args ...interface{}) {
+	for _, g := range l.Games() {
+		g := g
+		g.Do(func() {
+			g.SystemMessage(key, args...)
+		})
+	}
+}
+
+// disconnectAll closes the connections in rooms once what is queued for
+// them is sent, the ones in the lobby right away
+func (l *Lobby) disconnectAll() {
+	for _, g := range l.Games() {
+		g := g
+		g.Do(func() {
+			for c, ok := range g.clients {
+				if ok && c.bot == nil {
+					c.closeAfterSend(DisconnectShutdown)
+				}
+			}
+		})
+	}
+	for _, c := range l.Clients() {
+		if c.Room() == "" {
+			c.Close(DisconnectShutdown)
+		}
+	}
+	deadline := time.Now().Add(ShutdownDrain)
+	for len(l.Clients()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, c := range l.Clients() {
+		c.Close(DisconnectShutdown)
+	}
+}
+
+// SaveState writes the ranking and match of every room to path
+func (l *Lobby) SaveState(path string) error {
+	state := SavedState{Saved: time.Now(), Rooms: []RoomState{}}
+	for _, g := range l.Games() {
+		g := g
+		g.Do(func() {
+			state.Rooms = append(state.Rooms, g.snapshot())
+		})
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Restore brings back the rooms saved at path by the last shutdown, the
+// ones the config didn't create are created again. The file is removed so
+// the same state is never restored twice.
+func (l *Lobby) Restore(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	state := SavedState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	if age := time.Since(state.Saved); age > RestoreMaxAge {
+		slog.Info("Saved rooms too old to restore", "saved", state.Saved)
+		return nil
+	}
+	for _, s := range state.Rooms {
+		s := s
+		g, ok := l.Room(s.Name)
+		if !ok {
+			mode, err := ModeByName(s.Mode)
+			if err != nil {
+				slog.Warn("Room not restored", "room", s.Name, "err", err)
+				continue
+			}
+			if g, err = l.Create(s.Name, mode, BotConfig{}); err != nil {
+				slog.Warn("Room not restored", "room", s.Name, "err", err)
+				continue
+			}
+		}
+		if g.Mode.Name() != s.Mode {
+			slog.Warn("Room not restored, its mode changed", "room", s.Name)
+			continue
+		}
+		g.Do(func() {
+			g.restore(s)
+		})
+		slog.Info("Room restored", "room", g.Name)
+	}
+	return nil
+}
+
+// snapshot is the state of the room kept on a shutdown
+func (g *Game) snapshot() RoomState {
+	s := RoomState{
+		Name:     g.Name,
+		Mode:     g.Mode.Name(),
+		Match:    g.Match.State,
+		Ranking:  g.Ranking.Standings(),
+		Profiles: map[ksuid.KSUID]string{},
+	}
+	for c, ok := range g.clients {
+		if ok && c.profile != "" {
+			s.Profiles[c.ID] = c.profile
+		}
+	}
+	if m, ok := g.Mode.(RestorableMode); ok && g.Match.State == models.MatchRunning {
+		s.Remaining = Duration(time.Until(g.Match.Ends))
+		s.Scores = m.Scores()
+	}
+	return s
+}
+
+// restore puts back the ranking of s and its match if it was running and
+// the mode can carry it over, the other matches start over
+func (g *Game) restore(s RoomState) {
+	if s.Ranking != nil {
+		g.Ranking = s.Ranking
+		g.unclaimed = map[ksuid.KSUID]string{}
+		for _, r := range g.Ranking {
+			g.unclaimed[r.ID] = s.Profiles[r.ID]
+		}
+		g.claimBy = time.Now().Add(ClaimGrace)
+	}
+	g.Pmutex.RLock()
+	for c, ok := range g.clients {
+		if p, exist := g.Players[c.ID]; ok && exist {
+			g.claimRanking(c, p)
+		}
+	}
+	g.Pmutex.RUnlock()
+	m, ok := g.Mode.(RestorableMode)
+	if !ok || s.Match != models.MatchRunning || s.Remaining <= 0 {
+		return
+	}
+	g.StartRecording()
+	g.Mode.Start(g)
+	m.RestoreScores(s.Scores)
+	g.Match.State = models.MatchRunning
+	g.Match.Ends = time.Now().Add(time.Duration(s.Remaining))
+}
+
+// claimRanking gives a player the place in the ranking it had before a
+// restart. Players are known by their profile, bots have none and go by
+// name.
+func (g *Game) claimRanking(c *Client, p *models.PlayerMsg) {
+	name := strings.TrimSpace(p.Name)
+	for _, r := range g.Ranking {
+		profile, ok := g.unclaimed[r.ID]
+		if !ok || r.Bot != p.Bot {
+			continue
+		}
+		if p.Bot {
+			if strings.TrimSpace(r.Name) != name {
+				continue
+			}
+		} else if profile == "" || profile != c.profile {
+			continue
+		}
+		delete(g.unclaimed, r.ID)
+		r.ID = p.ID
+		return
+	}
+}
+
+// dropUnclaimed takes the restored places nobody claimed out of the
+// ranking once the grace period is over
+func (g *Game) dropUnclaimed() {
+	if len(g.unclaimed) == 0 || time.Now().Before(g.claimBy) {
+		return
+	}
+	ranking := make(Ranking, 0, len(g.Ranking))
+	for _, r := range g.Ranking {
+		if _, ok := g.unclaimed[r.ID]; !ok {
+			ranking = append(ranking, r)
+		}
+	}
+	g.Ranking = ranking
+	g.unclaimed = nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/juanefec/go-pixel-ao/models"
+	"github.com/segmentio/ksuid"
+)
+
+// ArrowMaxCharge is the arrow charge time that gives full speed and damage,
+// the same the client uses
+const ArrowMaxCharge = 2.5
+
+// spellInfo is what the server needs to know of a client spell to resolve
+// its hits on bots, numbers match NewSpellData in the client
+type spellInfo struct {
+	Type     string
+	Damage   float64 // negative heals, per second for aoe spells
+	Mana     bool    // damage goes to the mana instead of the health
+	Support  bool    // affects allies instead of enemies
+	ManaCost float64
+	Speed    float64 // projectiles
+	Life     float64 // seconds
+	Radius   float64 // aoe and traps
+}
+
+var spellBook = map[string]spellInfo{
+	"apoca":       {Type: "on-target", Damage: 190, ManaCost: 1000},
+	"desca":       {Type: "on-target", Damage: 130, ManaCost: 460},
+	"explo":       {Type: "on-target", Damage: 220, ManaCost: 1600},
+	"fireball":    {Type: "projectile", Damage: 80, ManaCost: 200, Speed: 280, Life: 1},
+	"icesnipe":    {Type: "projectile", Damage: 210, ManaCost: 800, Speed: 500, Life: 1},
+	"healshot":    {Type: "projectile", Damage: -60, Support: true, ManaCost: 350, Speed: 240, Life: 1.4},
+	"manashot":    {Type: "projectile", Damage: 400, Mana: true, ManaCost: 200, Speed: 250, Life: 1.4},
+	"rockshot":    {Type: "projectile", Damage: 120, ManaCost: 700, Speed: 230, Life: .9},
+	"arrowshot":   {Type: "casted-projectile", Damage: 230, ManaCost: 600, Speed: 480, Life: 1.5},
+	"lava-spot":   {Type: "aoe", Damage: 100, ManaCost: 1200, Life: 5, Radius: 70},
+	"heal-spot":   {Type: "aoe", Damage: -90, Support: true, ManaCost: 1200, Life: 4, Radius: 70},
+	"mana-spot":   {Type: "aoe", Damage: -350, Mana: true, Support: true, ManaCost: 1200, Life: 6, Radius: 70},
+	"hunter-trap": {Type: "trap", Damage: 50, ManaCost: 800, Life: 15, Radius: 16},
+}
+
+// activeSpell is a spell still flying or lying on the ground
+type activeSpell struct {
+	name         string
+	info         spellInfo
+	caster       ksuid.KSUID
+	fromX, fromY float64
+	x, y, vx, vy float64
+	damage       float64
+	expires      time.Time
+}
+
+// CastSpell follows a spell cast by a player or a bot so it can hit bots,
+// players resolve the hits on themselves in their clients
+func (g *Game) CastSpell(s models.SpellMsg) {
+	info, ok := spellBook[s.SpellName]
+	caster, exist := g.Players[s.ID]
+	if !ok || !exist || len(g.bots) == 0 {
+		return
+	}
+	if info.Type == "on-target" {
+		if b, ok := g.bots[s.TargetID]; ok && !b.Dead && g.spellAffects(s.SpellName, s.ID, b) {
+			g.hitBot(b, s.ID, info, info.Damage)
+		}
+		return
+	}
+	a := &activeSpell{
+		name:    s.SpellName,
+		info:    info,
+		caster:  s.ID,
+		fromX:   caster.X,
+		fromY:   caster.Y,
+		x:       s.X,
+		y:       s.Y,
+		damage:  info.Damage,
+		expires: time.Now().Add(time.Duration(info.Life * float64(time.Second))),
+	}
+	if info.Type == "projectile" || info.Type == "casted-projectile" {
+		speed := info.Speed
+		if info.Type == "casted-projectile" {
+			speed = mapRange(s.ChargeTime, 0, ArrowMaxCharge, 210, info.Speed)
+			a.damage = mapRange(s.ChargeTime, 0, ArrowMaxCharge, 25, info.Damage)
+		}
+		// clients move projectiles sqrt(2) times their speed
+		speed *= math.Sqrt2
+		dx, dy := s.X-caster.X, s.Y-caster.Y
+		l := math.Hypot(dx, dy)
+		if l == 0 {
+			return
+		}
+		a.x, a.y = caster.X, caster.Y
+		a.vx, a.vy = dx/l*speed, dy/l*speed
+	}
+	g.spells = append(g.spells, a)
+}
+
+// UpdateSpells moves the spells dt seconds and applies their hits on bots
+func (g *Game) UpdateSpells(dt float64) {
+	now := time.Now()
+	active := g.spells[:0]
+	for _, a := range g.spells {
+		if now.After(a.expires) || !g.updateSpell(a, dt) {
+			continue
+		}
+		active = append(active, a)
+	}
+	g.spells = active
+}
+
+// updateSpell reports if the spell is still active
+func (g *Game) updateSpell(a *activeSpell, dt float64) bool {
+	a.x += a.vx * dt
+	a.y += a.vy * dt
+	for _, b := range g.bots {
+		if b.Dead || !g.spellAffects(a.name, a.caster, b) {
+			continue
+		}
+		switch a.info.Type {
+		case "projectile", "casted-projectile":
+			if b.ID != a.caster && b.OnMe(a.x, a.y) {
+				damage := a.damage
+				if a.name == "icesnipe" {
+					damage = mapRange(math.Hypot(b.X-a.fromX, b.Y-a.fromY), 0, 500, 15, a.damage)
+				}
+				g.hitBot(b, a.caster, a.info, damage)
+				return false
+			}
+		case "aoe":
+			if models.InsideRadius(a.x, a.y, b.X, b.Y, a.info.Radius) {
+				g.hitBot(b, a.caster, a.info, a.damage*dt)
+			}
+		case "trap":
+			if models.InsideRadius(a.x, a.y, b.X, b.Y, a.info.Radius) {
+				g.hitBot(b, a.caster, a.info, a.damage)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// spellAffects is SpellAffects in the client for a spell landing on a bot
+func (g *Game) spellAffects(name string, caster ksuid.KSUID, b *Bot) bool {
+	c := g.clientByID(caster)
+	if c == nil || c.spectator || g.duels.Of(c) != nil {
+		return false
+	}
+	allies := caster == b.ID || (c.team != models.NoTeam && c.team == b.client.team)
+	if spellBook[name].Support {
+		return allies
+	}
+	if caster == b.ID {
+		return false
+	}
+	return g.FriendlyFire || !allies
+}
+
+func (g *Game) clientByID(id ksuid.KSUID) *Client {
+	for c, ok := range g.clients {
+		if ok && c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// hitBot applies damage to a bot, killing it if its health runs out
+func (g *Game) hitBot(b *Bot, caster ksuid.KSUID, info spellInfo, damage float64) {
+	if info.Mana {
+		b.MP = math.Max(0, math.Min(BotMaxMP, b.MP-damage))
+		return
+	}
+	b.HP = math.Min(BotMaxHP, b.HP-damage)
+	if damage > 0 && caster != b.ID {
+		b.attacker = caster
+	}
+	if b.HP > 0 {
+		return
+	}
+	b.HP = 0
+	b.Dead = true
+	b.diedAt = time.Now()
+	d := models.DeathMsg{Killed: b.ID, KilledName: b.Name, Killer: caster}
+	if p, ok := g.Players[caster]; ok {
+		d.KillerName = p.Name
+	}
+	payload, _ := json.Marshal(d)
+	g.Death(nil, payload)
+}
+
+// Damage hurts c from the server side, like the battle royale zone does
+func (g *Game) Damage(c *Client, dmg models.DamageMsg) {
+	if c.bot != nil {
+		if !c.bot.Dead {
+			g.hitBot(c.bot, ksuid.Nil, spellInfo{}, dmg.Amount)
+		}
+		return
+	}
+	payload, _ := json.Marshal(dmg)
+	c.push(models.NewMesg(models.Damage, payload))
+}
+
+// mapRange is Map in the client: v goes from [s1, st1] to [s2, st2],
+// clamped to the target range
+func mapRange(v, s1, st1, s2, st2 float64) float64 {
+	n := (v-s1)/(st1-s1)*(st2-s2) + s2
+	return math.Max(math.Min(s2, st2), math.Min(math.Max(s2, st2), n))
+}